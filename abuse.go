@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/xhzeem/ip2whois/pkg/ip2whois"
+)
+
+// Where -abuse looks for a contact, best first: the registrar's abuse
+// contact, then the registrant's and admin's addresses
+var abuseSources = []struct {
+	name         string
+	email, phone string
+}{
+	{"registrar abuse", "registrar.abuse_email", "registrar.abuse_phone"},
+	{"registrant", "registrant.email", "registrant.phone"},
+	{"admin", "admin.email", "admin.phone"},
+}
+
+// Find the best abuse contact in a record, naming where it came from.
+// Redacted placeholders aren't addresses, so only emails with an @ count.
+func abuseContact(record *ip2whois.Record) (email, phone, source string) {
+	for _, src := range abuseSources {
+		value, _ := lookupPath(record.Raw, strings.Split(src.email, "."))
+		email, _ = value.(string)
+		if !strings.Contains(email, "@") {
+			continue
+		}
+		value, _ = lookupPath(record.Raw, strings.Split(src.phone, "."))
+		phone, _ = value.(string)
+		return email, phone, src.name
+	}
+	return "", "", "none"
+}
+
+// Prints one tab-separated line per domain for -abuse: the domain, abuse
+// email, phone and which contact they came from, with - for missing values
+type abuseWriter struct {
+	w io.Writer
+}
+
+func (aw *abuseWriter) write(value string, record *ip2whois.Record, jsonData map[string]interface{}) error {
+	email, phone, source := abuseContact(record)
+	dash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	_, err := fmt.Fprintf(aw.w, "%s\t%s\t%s\t%s\n", value, dash(email), dash(phone), source)
+	return err
+}
+
+func (aw *abuseWriter) close() error {
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/xhzeem/ip2whois/pkg/ip2whois"
+)
+
+// Probe every key for -check-keys and print a table of which work and their
+// remaining credits, returning the number of keys that can't be used
+func checkKeys(ctx context.Context, w io.Writer, client *ip2whois.Client) (int, error) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tKEY\tSTATUS\tCREDITS")
+
+	unusable := 0
+	for i, key := range client.Keys {
+		credits, reported, err := client.CheckKey(ctx, i)
+		if ctx.Err() != nil {
+			return unusable, ctx.Err()
+		}
+
+		status := "valid"
+		switch {
+		case errors.Is(err, ip2whois.ErrInvalidKey):
+			status = "invalid"
+		case errors.Is(err, ip2whois.ErrQuotaExceeded):
+			status = "out of credits"
+		case err != nil:
+			status = "error: " + err.Error()
+		}
+		if err != nil {
+			unusable++
+		}
+
+		balance := "unknown"
+		switch {
+		case reported:
+			balance = fmt.Sprint(credits)
+		case errors.Is(err, ip2whois.ErrQuotaExceeded):
+			balance = "0"
+		case err != nil:
+			balance = "-"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", i+1, ip2whois.MaskKey(key), status, balance)
+	}
+	return unusable, tw.Flush()
+}
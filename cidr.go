@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// Ranges larger than this need -yes, and none may exceed cidrMaxHosts
+const (
+	cidrConfirmHosts = 256
+	cidrMaxHosts     = 65536
+)
+
+// Parse a single IPv4 or IPv6 address, rejecting zones the API can't use
+func parseIP(value string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if addr.Zone() != "" {
+		return netip.Addr{}, fmt.Errorf("zoned address %q is not supported", value)
+	}
+	return addr.Unmap(), nil
+}
+
+// Expand a CIDR block into its host addresses. IPv4 network and broadcast
+// addresses are skipped for blocks larger than /31.
+func expandCIDR(block string, confirmed bool) ([]string, error) {
+	prefix, err := netip.ParsePrefix(block)
+	if err != nil {
+		return nil, err
+	}
+	prefix = prefix.Masked()
+
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits > 16 {
+		return nil, fmt.Errorf("%s has more than %d addresses", prefix, cidrMaxHosts)
+	}
+	size := 1 << uint(hostBits)
+	if size > cidrConfirmHosts && !confirmed {
+		return nil, fmt.Errorf("%s expands to %d addresses; pass -yes to look them all up", prefix, size)
+	}
+
+	skipEnds := prefix.Addr().Is4() && hostBits >= 2
+	hosts := make([]string, 0, size)
+	addr := prefix.Addr()
+	for i := 0; i < size; i++ {
+		if !skipEnds || (i != 0 && i != size-1) {
+			hosts = append(hosts, addr.String())
+		}
+		addr = addr.Next()
+	}
+	return hosts, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/xhzeem/ip2whois/pkg/ip2whois"
+)
+
+// Longest CNAME chain -follow-cname follows before giving up
+const maxCNAMEHops = 10
+
+// Follow a name's CNAME records to the canonical name, one hop at a time so
+// loops are caught however the system resolver reports them. A name without
+// a CNAME resolves to itself.
+func resolveCNAME(ctx context.Context, name string) (string, error) {
+	seen := map[string]bool{name: true}
+	for hop := 0; hop < maxCNAMEHops; hop++ {
+		target, err := net.DefaultResolver.LookupCNAME(ctx, name)
+		if err != nil {
+			// A name with no DNS records at all may still be registered
+			var dnsErr *net.DNSError
+			if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+				return name, nil
+			}
+			return "", err
+		}
+
+		target = strings.ToLower(strings.TrimSuffix(target, "."))
+		if target == "" || target == name {
+			return name, nil
+		}
+		if seen[target] {
+			return "", fmt.Errorf("CNAME loop at %s", target)
+		}
+		seen[target] = true
+		name = target
+	}
+	return "", fmt.Errorf("CNAME chain longer than %d hops", maxCNAMEHops)
+}
+
+// Wrap a lookup so each domain's CNAME chain is followed first, and the
+// registrable domain of its target looked up instead. Records found through
+// an alias get a cname field naming the queried and canonical names; a
+// domain whose chain can't be followed is looked up as given.
+func followCNAME(base func(context.Context, string) (*ip2whois.Record, error), etld bool) func(context.Context, string) (*ip2whois.Record, error) {
+	return func(ctx context.Context, d string) (*ip2whois.Record, error) {
+		name, err := ip2whois.NormalizeDomain(d)
+		if err != nil {
+			return nil, err
+		}
+
+		target, err := resolveCNAME(ctx, name)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			logf("%s: warning: cannot follow CNAME records (%v), looking up %s itself", d, err, name)
+			target = name
+		}
+		if target == name && !etld {
+			return base(ctx, name)
+		}
+
+		query, err := ip2whois.RegistrableDomain(target)
+		if err != nil {
+			return nil, fmt.Errorf("CNAME target %s: %w", target, err)
+		}
+		if target != name {
+			logf("%s: CNAME resolves to %s, looking up %s", d, target, query)
+		}
+
+		record, err := base(ctx, query)
+		if err != nil || target == name {
+			return record, err
+		}
+
+		aliased := *record
+		aliased.Raw = copyObject(record.Raw)
+		aliased.Raw["cname"] = map[string]interface{}{"name": name, "target": target}
+		return &aliased, nil
+	}
+}
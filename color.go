@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/xhzeem/ip2whois/pkg/ip2whois"
+)
+
+// Records expiring within this many days are highlighted in red
+const colorExpiryDays = 30
+
+// ANSI SGR codes, all two characters long so every painted cell carries the
+// same number of invisible bytes and tabwriter columns stay aligned
+const (
+	colorDefault = "39"
+	colorBold    = "01"
+	colorRed     = "31"
+	colorGreen   = "32"
+	colorYellow  = "33"
+	colorBlue    = "34"
+	colorCyan    = "36"
+)
+
+// Report whether output to stdout should be colored: it is a terminal and
+// neither -no-color nor $NO_COLOR (see no-color.org) turned it off
+func useColor(noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Wrap text in an SGR code and a reset
+func paint(code, text string) string {
+	return "\x1b[" + code + "m" + text + "\x1b[0m"
+}
+
+// Report whether an expiry date falls within colorExpiryDays, or has passed
+func expiresSoon(t *time.Time) bool {
+	return t != nil && time.Until(*t) < colorExpiryDays*24*time.Hour
+}
+
+// Statuses that mean a domain is suspended or about to be released
+var alarmingStatuses = []string{"hold", "pendingdelete", "redemption"}
+
+// Report whether a status field holds one of alarmingStatuses
+func statusAlarming(status string) bool {
+	lowered := strings.ToLower(status)
+	for _, word := range alarmingStatuses {
+		if strings.Contains(lowered, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Color indented JSON: keys in blue, strings in green, other scalars in
+// cyan, and expire_date values within colorExpiryDays or alarming statuses
+// in red and yellow
+func colorizeJSON(formatted []byte) string {
+	var out strings.Builder
+	var lastKey string
+	for i := 0; i < len(formatted); i++ {
+		c := formatted[i]
+		switch {
+		case c == '"':
+			// Find the closing quote, skipping escaped characters
+			end := i + 1
+			for end < len(formatted) && formatted[end] != '"' {
+				if formatted[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			token := string(formatted[i : end+1])
+			i = end
+
+			// A string followed by a colon is a key
+			next := i + 1
+			for next < len(formatted) && strings.IndexByte(" \t\r\n", formatted[next]) >= 0 {
+				next++
+			}
+			if next < len(formatted) && formatted[next] == ':' {
+				lastKey = strings.Trim(token, `"`)
+				out.WriteString(paint(colorBlue, token))
+				continue
+			}
+
+			code := colorGreen
+			value := strings.Trim(token, `"`)
+			switch lastKey {
+			case "expire_date":
+				if t, err := ip2whois.ParseDate(value); err == nil && expiresSoon(&t) {
+					code = colorRed
+				}
+			case "status":
+				if statusAlarming(value) {
+					code = colorYellow
+				}
+			}
+			out.WriteString(paint(code, token))
+		case c == '-' || c >= '0' && c <= '9' || c == 't' || c == 'f' || c == 'n':
+			end := i
+			for end < len(formatted) && strings.IndexByte(",}] \t\n", formatted[end]) < 0 {
+				end++
+			}
+			out.WriteString(paint(colorCyan, string(formatted[i:end])))
+			i = end - 1
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
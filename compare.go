@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+
+	"github.com/xhzeem/ip2whois/pkg/ip2whois"
+)
+
+// A field that differs between a snapshot and the current record
+type fieldChange struct {
+	op       byte // '+' added, '-' removed, '~' changed
+	path     string
+	old, new interface{}
+}
+
+// Load earlier JSON output, keyed by the queried value or the domain. The file
+// may hold a single object, a JSON array or NDJSON; error objects are skipped.
+func loadSnapshots(path string) (map[string]map[string]interface{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	snapshots := make(map[string]map[string]interface{})
+	add := func(value interface{}) {
+		object, ok := value.(map[string]interface{})
+		if !ok || object["error"] != nil {
+			return
+		}
+		for _, key := range []string{"query", "domain"} {
+			if name, ok := object[key].(string); ok && name != "" {
+				snapshots[name] = object
+			}
+		}
+	}
+
+	decoder := json.NewDecoder(file)
+	for {
+		var value interface{}
+		if err := decoder.Decode(&value); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		if list, ok := value.([]interface{}); ok {
+			for _, item := range list {
+				add(item)
+			}
+		} else {
+			add(value)
+		}
+	}
+
+	if len(snapshots) == 0 {
+		return nil, fmt.Errorf("%s: no records found", path)
+	}
+	return snapshots, nil
+}
+
+// Recursively compare two decoded JSON values, walking objects and arrays
+func diffValues(path string, old, new interface{}, changes []fieldChange) []fieldChange {
+	switch n := new.(type) {
+	case map[string]interface{}:
+		o, ok := old.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		keys := make(map[string]bool)
+		for key := range o {
+			keys[key] = true
+		}
+		for key := range n {
+			keys[key] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+
+		for _, key := range sorted {
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			oldValue, inOld := o[key]
+			newValue, inNew := n[key]
+			switch {
+			case !inOld:
+				changes = append(changes, fieldChange{op: '+', path: child, new: newValue})
+			case !inNew:
+				changes = append(changes, fieldChange{op: '-', path: child, old: oldValue})
+			default:
+				changes = diffValues(child, oldValue, newValue, changes)
+			}
+		}
+		return changes
+	case []interface{}:
+		o, ok := old.([]interface{})
+		if !ok {
+			break
+		}
+
+		for i := 0; i < len(o) || i < len(n); i++ {
+			child := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(o):
+				changes = append(changes, fieldChange{op: '+', path: child, new: n[i]})
+			case i >= len(n):
+				changes = append(changes, fieldChange{op: '-', path: child, old: o[i]})
+			default:
+				changes = diffValues(child, o[i], n[i], changes)
+			}
+		}
+		return changes
+	}
+
+	if !reflect.DeepEqual(old, new) {
+		changes = append(changes, fieldChange{op: '~', path: path, old: old, new: new})
+	}
+	return changes
+}
+
+// Prints the fields that changed since the snapshot for each record
+type compareWriter struct {
+	w         io.Writer
+	snapshots map[string]map[string]interface{}
+}
+
+func (cw *compareWriter) write(value string, record *ip2whois.Record, jsonData map[string]interface{}) error {
+	snapshot, ok := cw.snapshots[value]
+	if !ok {
+		snapshot, ok = cw.snapshots[record.Domain]
+	}
+	if !ok {
+		logf("%s: warning: no snapshot to compare against", value)
+		return nil
+	}
+
+	current, err := roundTripJSON(jsonData)
+	if err != nil {
+		return err
+	}
+
+	// The queried value is an annotation, not part of the record
+	old := make(map[string]interface{}, len(snapshot))
+	for key, v := range snapshot {
+		old[key] = v
+	}
+	delete(old, "query")
+
+	changes := diffValues("", old, current, nil)
+	if len(changes) == 0 {
+		_, err := fmt.Fprintf(cw.w, "%s: no changes\n", value)
+		return err
+	}
+	return writeChanges(cw.w, value, changes)
+}
+
+func (cw *compareWriter) close() error {
+	return nil
+}
+
+// Print a heading with the number of changes, then one line per change
+func writeChanges(w io.Writer, heading string, changes []fieldChange) error {
+	if _, err := fmt.Fprintf(w, "%s: %d change(s)\n", heading, len(changes)); err != nil {
+		return err
+	}
+	for _, c := range changes {
+		var line string
+		switch c.op {
+		case '+':
+			line = fmt.Sprintf("  + %s: %s", c.path, describeValue(c.new))
+		case '-':
+			line = fmt.Sprintf("  - %s: %s", c.path, describeValue(c.old))
+		default:
+			line = fmt.Sprintf("  ~ %s: %s -> %s", c.path, describeValue(c.old), describeValue(c.new))
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config file loaded when -config isn't given, if it exists
+const defaultConfigName = ".ip2whois.yaml"
+
+// Locate the config file: -config if set, otherwise ~/.ip2whois.yaml when present
+func configPath(explicit string) string {
+	if explicit != "" {
+		return explicit
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	path := filepath.Join(home, defaultConfigName)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// Set flag defaults from a config file whose keys are flag names. Flags given
+// on the command line are left alone, and the API key environment variable
+// takes precedence over keys in the file.
+func applyConfig(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	options, err := parseConfig(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for name, value := range options {
+		if name == "config" || flag.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown option %q", path, name)
+		}
+		if explicit[name] {
+			continue
+		}
+		if (name == "k" || name == "kF") && os.Getenv(apiKeyEnv) != "" {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s: invalid value %q for %s: %v", path, value, name, err)
+		}
+	}
+	return nil
+}
+
+// Parse a flat JSON object, or "name: value" (YAML) and "name = value" (TOML) lines
+func parseConfig(data []byte) (map[string]string, error) {
+	options := make(map[string]string)
+
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+		var object map[string]interface{}
+		if err := json.Unmarshal(data, &object); err != nil {
+			return nil, err
+		}
+		for name, value := range object {
+			options[name] = configValue(value)
+		}
+		return options, nil
+	}
+
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+
+		i := strings.IndexAny(line, ":=")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected name: value", n+1)
+		}
+		name := strings.TrimSpace(line[:i])
+		value := strings.TrimSpace(line[i+1:])
+
+		// Flow lists such as [a, b] become comma-separated values
+		if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+			items := splitList(value[1 : len(value)-1])
+			for j, item := range items {
+				items[j] = unquote(item)
+			}
+			value = strings.Join(items, ",")
+		} else {
+			value = unquote(value)
+		}
+		options[name] = value
+	}
+	return options, nil
+}
+
+// Convert a JSON config value to its flag form
+func configValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = configValue(item)
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Strip matching single or double quotes around a value
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/xhzeem/ip2whois/pkg/ip2whois"
+)
+
+// Print the requests a run would make with the primary provider and the
+// options in effect, without calling the API
+func dryRun(w io.Writer, client *ip2whois.Client, primary ip2whois.Provider, queryType string, inputs []string, normalize func(string) (string, error)) {
+	masked := make([]string, len(client.Keys))
+	for i, key := range client.Keys {
+		masked[i] = ip2whois.MaskKey(key)
+	}
+	if len(masked) > 0 {
+		fmt.Fprintf(w, "Keys: %d (%s)\n", len(client.Keys), strings.Join(masked, ", "))
+	} else {
+		fmt.Fprintln(w, "Keys: none")
+	}
+
+	// Only explicitly set flags are listed, with key values masked
+	var options []string
+	flag.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		switch f.Name {
+		case "k":
+			value = strings.Join(masked, ",")
+		case "H":
+			// Gateway headers usually carry credentials too
+			var names []string
+			for name := range f.Value.(headerFlag) {
+				names = append(names, name+": ***")
+			}
+			sort.Strings(names)
+			value = strings.Join(names, ", ")
+		case "proxy", "api-url", "rdap-url":
+			value = redactFlagURL(value)
+		}
+		options = append(options, fmt.Sprintf("-%s=%s", f.Name, value))
+	})
+	if len(options) == 0 {
+		options = append(options, "(defaults)")
+	}
+	fmt.Fprintf(w, "Options: %s\n", strings.Join(options, " "))
+
+	requests, skipped := 0, 0
+	for _, input := range inputs {
+		value, err := normalize(input)
+		if rdap, ok := primary.(*ip2whois.RDAP); ok && err == nil {
+			var reqURL string
+			if reqURL, err = rdap.RequestURL(value); err == nil {
+				fmt.Fprintf(w, "GET %s (rdap)\n", reqURL)
+				requests++
+				continue
+			}
+		} else if whois, ok := primary.(*ip2whois.WHOIS); ok && err == nil {
+			fmt.Fprintf(w, "WHOIS %s (port 43, starting at %s)\n", value, whois.Server)
+			requests++
+			continue
+		} else if err == nil {
+			var reqURL string
+			if reqURL, err = client.RequestURL(queryType, value); err == nil {
+				if len(client.Keys) == 0 {
+					fmt.Fprintf(w, "GET %s (no API key)\n", reqURL)
+					requests++
+					continue
+				}
+				// Mirror the key each lookup would start with
+				key := 0
+				if client.RoundRobin {
+					key = requests % len(client.Keys)
+				}
+				fmt.Fprintf(w, "GET %s (key #%d %s)\n", reqURL, key+1, masked[key])
+				requests++
+				continue
+			}
+		}
+		fmt.Fprintf(w, "SKIP %s: %v\n", input, err)
+		skipped++
+	}
+
+	fmt.Fprintf(w, "Dry run: %d request(s) would be made, %d input(s) skipped\n", requests, skipped)
+}
+
+// Hide the password and any key parameter in a URL-valued flag
+func redactFlagURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		return value
+	}
+	if params := u.Query(); params.Get("key") != "" {
+		params.Del("key")
+		u.RawQuery = "key=***"
+		if encoded := params.Encode(); encoded != "" {
+			u.RawQuery += "&" + encoded
+		}
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xhzeem/ip2whois/pkg/ip2whois"
+)
+
+// Compute the whole days until the record expires, relative to now
+func daysToExpiry(record *ip2whois.Record, now time.Time) (int, error) {
+	if record.ExpireDate == nil {
+		// Tell a missing date apart from one we couldn't parse
+		if value, ok := record.Raw["expire_date"].(string); ok && value != "" {
+			_, err := ip2whois.ParseDate(value)
+			return 0, fmt.Errorf("expire_date: %w", err)
+		}
+		return 0, errors.New("expire_date is missing")
+	}
+	return int(record.ExpireDate.Sub(now).Hours() / 24), nil
+}
+
+// Compute the whole days since the record was created, relative to now
+func daysSinceCreation(record *ip2whois.Record, now time.Time) (int, error) {
+	if record.CreateDate == nil {
+		if value, ok := record.Raw["create_date"].(string); ok && value != "" {
+			_, err := ip2whois.ParseDate(value)
+			return 0, fmt.Errorf("create_date: %w", err)
+		}
+		return 0, errors.New("create_date is missing")
+	}
+	return int(now.Sub(*record.CreateDate).Hours() / 24), nil
+}
+
+// Decides whether a record is output; an error means it couldn't be evaluated
+type recordFilter func(record *ip2whois.Record) (bool, error)
+
+// Keep only records expiring within the given number of days, including expired ones
+func expiringWithin(days int) recordFilter {
+	return func(record *ip2whois.Record) (bool, error) {
+		// An unregistered domain has no expiry date to be near
+		if isAvailable(record) {
+			return false, nil
+		}
+		remaining, err := daysToExpiry(record, time.Now())
+		if err != nil {
+			return false, err
+		}
+		return remaining <= days, nil
+	}
+}
+
+// Keep only records created within the given number of days, such as
+// freshly registered phishing domains
+func createdWithin(days int) recordFilter {
+	return func(record *ip2whois.Record) (bool, error) {
+		// An unregistered domain has no creation date to be recent
+		if isAvailable(record) {
+			return false, nil
+		}
+		age, err := daysSinceCreation(record, time.Now())
+		if err != nil {
+			return false, err
+		}
+		return age <= days, nil
+	}
+}
+
+// Keep only records with at least one of the EPP statuses, ignoring case
+func statusIn(statuses []string) recordFilter {
+	wanted := make(map[string]bool, len(statuses))
+	for _, status := range statuses {
+		wanted[strings.ToLower(status)] = true
+	}
+	return func(record *ip2whois.Record) (bool, error) {
+		// Registrars separate statuses with spaces or commas, sometimes followed by an ICANN URL
+		for _, status := range strings.FieldsFunc(record.Status, func(r rune) bool {
+			return r == ',' || r == ' '
+		}) {
+			if wanted[strings.ToLower(status)] {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// Keep only records whose registrar name contains the text, ignoring case
+func registrarContains(text string) recordFilter {
+	text = strings.ToLower(text)
+	return func(record *ip2whois.Record) (bool, error) {
+		return strings.Contains(strings.ToLower(record.Registrar.Name), text), nil
+	}
+}
+
+// Build the result for a domain the API has no WHOIS record for
+func availableRecord(domain string) *ip2whois.Record {
+	raw := map[string]interface{}{"domain": domain, "available": true}
+	body, _ := json.Marshal(raw)
+	return &ip2whois.Record{Domain: domain, Raw: raw, Body: body, Nameservers: []string{}}
+}
+
+// Report whether a record stands for an unregistered domain
+func isAvailable(record *ip2whois.Record) bool {
+	available, _ := record.Raw["available"].(bool)
+	return available
+}
+
+// Keep only registered domains, or only available ones
+func availability(wantAvailable bool) recordFilter {
+	return func(record *ip2whois.Record) (bool, error) {
+		return isAvailable(record) == wantAvailable, nil
+	}
+}
+
+// Run every filter, stopping at the first that rejects the record or fails
+func applyFilters(filters []recordFilter, record *ip2whois.Record) (bool, error) {
+	for _, filter := range filters {
+		if keep, err := filter(record); !keep || err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
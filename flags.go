@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// A byte count flag accepting plain numbers or K, M and G suffixes (powers of 1024)
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(value string) error {
+	number := strings.ToUpper(strings.TrimSpace(value))
+	number = strings.TrimSuffix(number, "B")
+
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(number, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(number, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(number, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		number = number[:len(number)-1]
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", value)
+	}
+	*b = byteSize(n * multiplier)
+	return nil
+}
+
+// A repeatable "Name: Value" flag collecting request headers, as with curl -H
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	var pairs []string
+	for name, values := range h {
+		for _, value := range values {
+			pairs = append(pairs, name+": "+value)
+		}
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func (h headerFlag) Set(value string) error {
+	i := strings.Index(value, ":")
+	if i <= 0 {
+		return fmt.Errorf("header %q must look like \"Name: Value\"", value)
+	}
+	http.Header(h).Add(strings.TrimSpace(value[:i]), strings.TrimSpace(value[i+1:]))
+	return nil
+}
+
+// A repeatable flag collecting values in order; each use may also hold a
+// comma-separated list, as config file lists are passed
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, splitList(value)...)
+	return nil
+}
@@ -0,0 +1,13 @@
+module github.com/xhzeem/ip2whois
+
+go 1.26.0
+
+require (
+	github.com/itchyny/gojq v0.12.19
+	github.com/santhosh-tekuri/jsonschema/v6 v6.0.3
+	golang.org/x/net v0.59.0
+	golang.org/x/text v0.42.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require github.com/itchyny/timefmt-go v0.1.8 // indirect
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A -json-input document: the keys and domains to look up, and flag values
+// by flag name, as in a JSON config file
+type jsonInput struct {
+	Keys    []string               `json:"keys"`
+	Domains []string               `json:"domains"`
+	Options map[string]interface{} `json:"options"`
+}
+
+// Options a -json-input document can't set
+var jsonInputReserved = map[string]bool{"json-input": true, "config": true, "k": true, "kF": true, "d": true}
+
+// Output format flags; -json-input emits a JSON array unless one is chosen
+var formatFlags = []string{"raw", "whois-text", "ndjson", "json-array", "csv", "table", "yaml", "compare", "query", "template", "abuse"}
+
+// Decode and check a -json-input document, which must be a single object
+func readJSONInput(r io.Reader) (*jsonInput, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	var input jsonInput
+	if err := decoder.Decode(&input); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case err == io.EOF:
+			return nil, errors.New("no input; expected a JSON object with keys, domains and options")
+		case errors.As(err, &typeErr) && typeErr.Field != "":
+			return nil, fmt.Errorf("%s must be %s, not %s", indexPath(typeErr.Field), describeGoType(typeErr.Type.String()), typeErr.Value)
+		case errors.As(err, &typeErr):
+			return nil, fmt.Errorf("expected a JSON object, not %s", typeErr.Value)
+		case strings.HasPrefix(err.Error(), "json: unknown field"):
+			return nil, fmt.Errorf("%s; expected keys, domains and options", strings.TrimPrefix(err.Error(), "json: "))
+		}
+		return nil, err
+	}
+	var extra json.RawMessage
+	if err := decoder.Decode(&extra); err != io.EOF {
+		return nil, errors.New("unexpected data after the JSON object")
+	}
+
+	for i, key := range input.Keys {
+		if strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("keys[%d] is empty", i)
+		}
+	}
+	for i, d := range input.Domains {
+		if strings.TrimSpace(d) == "" {
+			return nil, fmt.Errorf("domains[%d] is empty", i)
+		}
+	}
+	for name := range input.Options {
+		if jsonInputReserved[name] {
+			return nil, fmt.Errorf("options.%s can't be set in -json-input; use keys and domains", name)
+		}
+		if flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("options.%s is not a known option", name)
+		}
+	}
+	return &input, nil
+}
+
+// Write array elements of a decoder field path as domains[0] rather than domains.0
+func indexPath(field string) string {
+	parts := strings.Split(field, ".")
+	path := parts[0]
+	for _, part := range parts[1:] {
+		if _, err := strconv.Atoi(part); err == nil {
+			path += "[" + part + "]"
+		} else {
+			path += "." + part
+		}
+	}
+	return path
+}
+
+// Name the JSON type a Go type decodes from
+func describeGoType(goType string) string {
+	switch {
+	case strings.HasPrefix(goType, "[]"):
+		return "an array"
+	case strings.HasPrefix(goType, "map["):
+		return "an object"
+	}
+	return "a " + goType
+}
+
+// Set flags from a -json-input document. Flags given on the command line
+// win; the document's options in turn win over the config file, which is
+// applied afterwards.
+func (input *jsonInput) apply() error {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	names := make([]string, 0, len(input.Options))
+	for name := range input.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if explicit[name] {
+			continue
+		}
+		value := configValue(input.Options[name])
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("options.%s: invalid value %q: %v", name, value, err)
+		}
+	}
+
+	if len(input.Keys) > 0 && !explicit["k"] && !explicit["kF"] {
+		flag.Set("k", strings.Join(input.Keys, ","))
+	}
+	for _, d := range input.Domains {
+		if err := flag.Set("d", d); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range formatFlags {
+		if value := flag.Lookup(name).Value.String(); value != "" && value != "false" {
+			return nil
+		}
+	}
+	return flag.Set("json-array", "true")
+}
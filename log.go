@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/xhzeem/ip2whois/pkg/ip2whois"
+)
+
+// Set by -q to silence informational output on stderr
+var quiet bool
+
+// Set by -log-format json to emit diagnostics as JSON lines instead of text
+var jsonLog *slog.Logger
+
+// Switch diagnostics to JSON lines on stderr
+func useJSONLog() {
+	jsonLog = slog.New(slog.NewJSONHandler(stderr, nil))
+}
+
+// Print an informational message to stderr unless -q is set
+func logf(format string, args ...interface{}) {
+	if quiet {
+		return
+	}
+	if jsonLog != nil {
+		jsonLog.Info(strings.TrimSpace(fmt.Sprintf(format, args...)))
+		return
+	}
+	fmt.Fprintf(stderr, format+"\n", args...)
+}
+
+// Print an error to stderr, even with -q
+func logError(format string, args ...interface{}) {
+	if jsonLog != nil {
+		jsonLog.Error(strings.TrimSpace(fmt.Sprintf(format, args...)))
+		return
+	}
+	fmt.Fprintf(stderr, format+"\n", args...)
+}
+
+// Report a domain whose lookup failed unless -q is set
+func logFailure(value string, err error) {
+	if quiet {
+		return
+	}
+	if jsonLog != nil {
+		attrs := []interface{}{"domain", value, "error", err.Error()}
+		// Describe the request the lookup gave up after
+		var lookupErr *ip2whois.LookupError
+		if errors.As(err, &lookupErr) && len(lookupErr.Attempts) > 0 {
+			last := lookupErr.Attempts[len(lookupErr.Attempts)-1]
+			attrs = append(attrs,
+				"key_index", last.KeyIndex+1,
+				"key", last.Key,
+				"status", last.Status,
+				"latency_ms", last.Latency.Milliseconds(),
+				"attempts", len(lookupErr.Attempts),
+			)
+		}
+		jsonLog.Warn("lookup failed", attrs...)
+		return
+	}
+	fmt.Fprintf(stderr, "%s: %v\n", value, err)
+}
+
+// Print a heading followed by one indented line per item
+func logList(heading string, items []string) {
+	if jsonLog != nil {
+		jsonLog.Error(heading, "items", items)
+		return
+	}
+	fmt.Fprintf(stderr, "\n%s:\n", heading)
+	for _, item := range items {
+		fmt.Fprintf(stderr, "  %s\n", item)
+	}
+}
+
+// Log a request attempt for -v
+func logAttempt(attempt ip2whois.Attempt) {
+	result := fmt.Sprintf("status %d", attempt.Status)
+	if attempt.Err != nil {
+		result = attempt.Err.Error()
+	}
+
+	if jsonLog != nil {
+		attrs := []interface{}{
+			"domain", attempt.Value,
+			"key_index", attempt.KeyIndex + 1,
+			"key", attempt.Key,
+			"url", attempt.URL,
+			"status", attempt.Status,
+			"latency_ms", attempt.Latency.Milliseconds(),
+			"decision", attempt.Decision,
+		}
+		if attempt.Err != nil {
+			attrs = append(attrs, "error", attempt.Err.Error())
+		}
+		jsonLog.Info("request", attrs...)
+		return
+	}
+
+	log.New(stderr, "", log.LstdFlags).Printf("%s: key #%d (%s) GET %s -> %s in %s, %s",
+		attempt.Value, attempt.KeyIndex+1, attempt.Key, attempt.URL, result,
+		attempt.Latency.Round(time.Millisecond), attempt.Decision)
+}
@@ -1,136 +1,1274 @@
 package main
 
 import (
-	"encoding/json"
+	"compress/gzip"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/xhzeem/ip2whois/pkg/ip2whois"
 )
 
-// Fetch the IP2Whois API with a given key and domain
-func fetchIP2Whois(apiKey, domain string) (string, error) {
-	url := fmt.Sprintf("https://api.ip2whois.com/v2?key=%s&domain=%s", apiKey, domain)
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
+// Process exit codes
+const (
+	exitOK           = 0   // every lookup succeeded
+	exitUsage        = 1   // invalid flags or unreadable input
+	exitLookupFailed = 2   // one or more domains failed with every key
+	exitExpiring     = 3   // -expiring-in matched at least one domain
+	exitDeadline     = 4   // -deadline passed before the run finished
+	exitInterrupted  = 130 // stopped by SIGINT or SIGTERM
+)
+
+// Environment variable holding comma-separated API keys when -k is not given
+const apiKeyEnv = "IP2WHOIS_API_KEY"
+
+// Log the credit balance reported alongside a record
+func logCredits(value string, record *ip2whois.Record) {
+	if credits, ok := ip2whois.RemainingCredits(record.Raw); ok {
+		logf("%s: key #%d has %v credits remaining", value, record.KeyIndex+1, credits)
+	} else {
+		logf("%s: key #%d did not report a credit balance", value, record.KeyIndex+1)
+	}
+}
+
+func main() {
+	// Command line flags
+	apiKeys := flag.String("k", "", "Comma-separated list of API keys for ip2whois (defaults to $"+apiKeyEnv+")")
+	keyFile := flag.String("kF", "", "File containing one API key per line")
+	var domainArgs listFlag
+	flag.Var(&domainArgs, "d", "Domain to fetch the whois information for; repeat, or separate with commas, for several")
+	domainList := flag.String("dL", "", "File containing a newline-delimited list of domains")
+	serveAddr := flag.String("serve", "", "Run an HTTP server on this `address` (e.g. :8080) answering GET /whois?domain=NAME with JSON and GET /healthz")
+	dryRunMode := flag.Bool("dry-run", false, "Print the request each lookup would make and the options in effect, then exit without calling the API")
+	followCNAMEs := flag.Bool("follow-cname", false, "Follow each domain's CNAME chain and look up the registrable domain of its target, adding a cname field naming both")
+	dedupe := flag.Bool("dedupe", true, "Look up inputs that normalize to the same domain (with -etld, the same registrable domain) only once")
+	etld := flag.Bool("etld", false, "Reduce each domain to its registrable name (eTLD+1), e.g. mail.example.co.uk to example.co.uk")
+	ipAddress := flag.String("ip", "", "IPv4 or IPv6 address to fetch the whois information for instead of a domain")
+	cidrBlock := flag.String("cidr", "", "IPv4 or IPv6 CIDR block to look up every host address of (e.g. 192.0.2.0/28)")
+	confirm := flag.Bool("yes", false, fmt.Sprintf("Allow -cidr to expand blocks of more than %d addresses (up to %d)", cidrConfirmHosts, cidrMaxHosts))
+	raw := flag.Bool("raw", false, "Print the API response unmodified")
+	whoisText := flag.Bool("whois-text", false, "Print the registry's and registrar's plain-text WHOIS replies, queried directly over port 43 without the API")
+	whoisServer := flag.String("whois-server", ip2whois.DefaultWHOISServer, "WHOIS server asked which registry serves each TLD, as host or host:port")
+	hideRedacted := flag.Bool("clean", false, "Hide redacted, empty and null fields; same as -drop-redacted -drop-empty -drop-null")
+	dropRedacted := flag.Bool("drop-redacted", false, "Hide fields containing a -clean-words placeholder such as 'REDACTED'")
+	dropEmpty := flag.Bool("drop-empty", false, "Hide empty strings, and objects and arrays left empty")
+	dropNull := flag.Bool("drop-null", false, "Hide null fields")
+	keepEmpty := flag.Bool("keep-empty", false, "Keep empty and null fields even with -clean, and output -fields paths missing from the response as null")
+	cleanWords := flag.String("clean-words", strings.Join(ip2whois.DefaultCleanWords, ","), "Comma-separated, case-insensitive placeholders removed by -clean and -drop-redacted")
+	apiURL := flag.String("api-url", "", "Override the API endpoint (default "+ip2whois.DefaultBaseURL+", or "+ip2whois.DefaultIPBaseURL+" with -ip)")
+	providerName := flag.String("provider", "ip2whois", "Provider to look domains up with: ip2whois, or rdap or whois (port 43), which need no API key")
+	direct := flag.Bool("direct", false, "Query WHOIS servers directly over port 43 instead of the API; same as -provider whois")
+	fallback := flag.String("fallback", "", "Comma-separated providers to try in turn when -provider fails, e.g. rdap")
+	rdapFallback := flag.Bool("rdap-fallback", false, "Query RDAP when a domain has no WHOIS data, using its record for unknown domains and filling in empty fields otherwise")
+	free := flag.Bool("free", false, "Experimental: without API keys, look domains up with public RDAP and WHOIS servers instead, at reduced fidelity")
+	rdapURL := flag.String("rdap-url", ip2whois.DefaultRDAPURL, "RDAP service queried by the rdap provider")
+	followRedirects := flag.Bool("follow-redirects", false, "Follow API redirects to other hosts, which receive the API key; by default only same-host redirects are followed")
+	proxy := flag.String("proxy", "", "Proxy URL for API requests (http, https or socks5)")
+	maxSize := byteSize(ip2whois.DefaultMaxResponseSize)
+	flag.Var(&maxSize, "max-size", "Largest response body to accept, as a `size` in bytes or with a K, M or G suffix; 0 for no limit")
+	headers := headerFlag{}
+	flag.Var(headers, "H", "Extra `header` (\"Name: Value\") to send with every API request; repeatable")
+	caCert := flag.String("ca-cert", "", "PEM file of extra CA certificates to trust, such as a TLS-inspecting proxy's")
+	insecure := flag.Bool("insecure", false, "Skip TLS certificate verification (unsafe; only for debugging)")
+	userAgent := flag.String("user-agent", "ip2whois-cli/"+version, "User-Agent header sent with API requests")
+	timeout := flag.Int("timeout", 30, "HTTP request timeout in seconds")
+	minSuccess := flag.Float64("min-success", -1, "Exit 0 despite failed domains if at least this percentage of domains succeeded (e.g. 95)")
+	perDomainTimeout := flag.Duration("per-domain-timeout", 0, "Give up on a domain after this long across all retries, keys and providers (e.g. 45s), counting it as a timeout")
+	deadline := flag.Duration("deadline", 0, "Abandon the run after this long overall (e.g. 10m), exiting with code 4")
+	concurrency := flag.Int("c", 5, "Number of concurrent lookups")
+	rps := flag.Float64("rps", 0, "Maximum API requests per second across all workers (0 for unlimited)")
+	perKey := flag.Int("per-key-concurrency", 0, "Maximum requests in flight per API key (0 for no limit); lookups prefer keys with a free slot")
+	rotate := flag.Bool("rotate", false, "Start each lookup at the next API key in turn to spread quota usage")
+	showCredits := flag.Bool("show-credits", false, "Log the remaining credit balance after each successful call")
+	retries := flag.Int("retries", 2, "Retries per key on rate limiting (429) and server (5xx) errors")
+	validate := flag.Bool("validate", false, "Warn when a domain response is missing fields or has fields of unexpected types")
+	strict := flag.Bool("strict", false, "Like -validate, but count responses that don't match as failures")
+	batchSize := flag.Int("batch-size", 0, "Process domains in batches of N, waiting for each batch to finish before starting the next (0 for no batches)")
+	batchPause := flag.Duration("batch-pause", 0, "How long to pause between -batch-size batches (e.g. 1h)")
+	sampleCount := flag.Int("sample", 0, "Look up only N domains picked at random from the input, to try options out before a full run")
+	samplePct := flag.Float64("sample-pct", 0, "Look up only this percentage of the input's domains, picked at random (e.g. 1)")
+	sampleSeed := flag.Int64("seed", 0, "Seed for -sample and -sample-pct, to pick the same domains again (0 for a random seed, which is logged)")
+	keyRetries := flag.Int("key-retries", 0, "Times to retry the whole key set, after -key-cooldown, when every key failed on quota or transient errors")
+	keyCooldown := flag.Duration("key-cooldown", time.Minute, "How long to wait before retrying the key set with -key-retries")
+	cacheDir := flag.String("cache", "", "Directory to cache successful responses in")
+	cacheTTL := flag.Duration("cache-ttl", 24*time.Hour, "How long cached responses are reused")
+	outputFile := flag.String("o", "", "File to write the output to instead of stdout")
+	resumeFile := flag.String("resume", "", "Checkpoint file recording completed domains; domains already in it are skipped on restart")
+	failOut := flag.String("fail-out", "", "File to write domains that failed with every API key to, one per line, for a later -dL run (invalid and unregistered domains are left out)")
+	gzipOutput := flag.Bool("gzip", false, "Gzip the -o file, adding .gz to its name if missing")
+	appendOutput := flag.Bool("append", false, "Append to the -o file instead of truncating it")
+	fields := flag.String("fields", "", "Comma-separated list of dotted field paths to output (e.g. registrar.name,expire_date)")
+	renameFile := flag.String("rename", "", "File mapping dotted field paths to top-level output keys, as \"registrar.name: registrarName\" lines or a JSON object")
+	renameStrict := flag.Bool("rename-strict", false, "With -rename, output only the mapped fields")
+	fieldsExclude := flag.String("fields-exclude", "", "Comma-separated list of dotted field paths to remove from the output (e.g. domain_id,billing)")
+	expiry := flag.Bool("expiry", false, "Add a days_to_expiry field computed from expire_date (-1 if unknown)")
+	expiringIn := flag.Int("expiring-in", -1, "Only output domains expiring within N days and exit with code 3 if any match")
+	createdIn := flag.Int("created-within", -1, "Only output domains created within the last N days; domains without a usable create_date are listed separately")
+	onlyRegistered := flag.Bool("only-registered", false, "Only output domains that are registered")
+	onlyAvailable := flag.Bool("only-available", false, "Only output domains without a WHOIS record, which are likely available")
+	registrar := flag.String("registrar", "", "Only output domains whose registrar.name contains this text, ignoring case (e.g. markmonitor)")
+	statuses := flag.String("status", "", "Only output domains with one of these comma-separated statuses (e.g. clientHold,pendingDelete), ignoring case")
+	flag.BoolVar(&quiet, "q", false, "Suppress informational output on stderr; errors that cause a non-zero exit are still printed")
+	logFormat := flag.String("log-format", "text", "Format of diagnostics on stderr: text or json")
+	metricsFile := flag.String("metrics-file", "", "File to write Prometheus text-format metrics about the run to, e.g. for node_exporter's textfile collector")
+	abuse := flag.Bool("abuse", false, "Print one tab-separated line per domain of the registrar's abuse email and phone, falling back to the registrant's or admin's")
+	explain := flag.Bool("explain", false, "For each failed domain, list every request made with its key, HTTP status and error")
+	showStats := flag.Bool("stats", false, "Print the API request count, success rate and p50/p90/p99 latencies after the run")
+	verbose := flag.Bool("v", false, "Log every API request with its status, latency and outcome")
+	noProgress := flag.Bool("no-progress", false, "Don't show progress on stderr during batch runs (a live bar on a terminal, otherwise a line every 30s)")
+	noColor := flag.Bool("no-color", false, "Never color -table and indented JSON output (also set by $NO_COLOR); color is only used when stdout is a terminal")
+	indent := flag.String("indent", "  ", `JSON indentation per level, such as "\t"; empty for compact output (-ndjson is always compact)`)
+	includeRaw := flag.Bool("include-raw", false, "Nest the untouched API response under _raw, alongside the cleaned and selected fields")
+	flatten := flag.Bool("flatten", false, "Flatten nested objects into one level of dotted keys, such as registrar.name")
+	flattenArrays := flag.String("flatten-arrays", "index", "How -flatten stores arrays: index (nameservers.0, nameservers.1) or join (one string separated by ;)")
+	normalizeContacts := flag.Bool("normalize-contacts", false, "Always output the registrant, admin, tech and billing blocks with every contact field, empty if missing")
+	normalizeNS := flag.Bool("normalize-ns", false, "Output nameservers as a lowercased, de-duplicated and sorted list, empty if missing")
+	sortKeys := flag.Bool("sort-keys", false, "Also sort arrays of strings, such as nameservers, for byte-stable output (object keys are always sorted)")
+	ndjson := flag.Bool("ndjson", false, "Emit one compact JSON object per line, annotated with the queried domain")
+	yamlOutput := flag.Bool("yaml", false, "Emit YAML documents instead of JSON, separated by ---")
+	tableOutput := flag.Bool("table", false, "Print an aligned table of the key WHOIS fields")
+	jsonArray := flag.Bool("json-array", false, "Collect all results, including failures, into a single JSON array")
+	csvOutput := flag.Bool("csv", false, "Emit CSV with domain, registrar, dates, status and nameservers columns")
+	templateText := flag.String("template", "", "Format each record with a Go template, e.g. '{{.domain}} expires {{.expire_date | date \"2006-01-02\"}}'; helpers: date, default, join, lower, upper")
+	queryExpr := flag.String("query", "", "Print the results of a jq expression (e.g. .registrar.name) for each record, strings unquoted")
+	checkKeysMode := flag.Bool("check-keys", false, "Probe each API key with one lookup and print whether it works and its remaining credits, then exit (code 2 if any key is unusable)")
+	watchEvery := flag.Duration("watch", 0, "Re-query the domains at this interval (e.g. 15m) until interrupted, printing registrar, nameserver, status and expiry changes")
+	compareFile := flag.String("compare", "", "Print the fields that changed since an earlier JSON, JSON array or NDJSON snapshot file")
+	jsonInputMode := flag.Bool("json-input", false, "Read a JSON object from stdin of keys, domains and options (flag values by name), and output a JSON array")
+	configFile := flag.String("config", "", "File of default flag values as name: value lines or a JSON object (default ~/"+defaultConfigName+" if present)")
+	showVersion := flag.Bool("version", false, "Print version information and exit")
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(out, "\nDomains are normalized before querying: the scheme, user info, port, path,\n")
+		fmt.Fprintf(out, "query, trailing dot and a leading www. are removed, and the name is lowercased\n")
+		fmt.Fprintf(out, "and converted to Punycode. Invalid names are skipped without an API call.\n")
+		fmt.Fprintf(out, "\nJSON and YAML object keys are always sorted alphabetically. -sort-keys also\n")
+		fmt.Fprintf(out, "sorts every array of strings case-insensitively; arrays of objects keep their order.\n")
+		fmt.Fprintf(out, "\n-flatten joins the keys of nested objects with \".\", as in registrar.name, and\n")
+		fmt.Fprintf(out, "applies last, after -fields and -fields-exclude select by the nested paths. With\n")
+		fmt.Fprintf(out, "-csv it writes a column per key of the first record; -flatten-arrays join keeps\n")
+		fmt.Fprintf(out, "those columns stable when records have different numbers of nameservers.\n")
+		fmt.Fprintf(out, "\nOptions are taken from, in increasing precedence: built-in defaults, the config\n")
+		fmt.Fprintf(out, "file, and the command line. $%s overrides keys set in the config file.\n", apiKeyEnv)
+		fmt.Fprintf(out, "\nExit codes:\n")
+		fmt.Fprintf(out, "  %d  all lookups succeeded\n", exitOK)
+		fmt.Fprintf(out, "  %d  usage error or unreadable input\n", exitUsage)
+		fmt.Fprintf(out, "  %d  one or more domains failed with every API key, or too many for -min-success\n", exitLookupFailed)
+		fmt.Fprintf(out, "  %d  -expiring-in matched at least one domain\n", exitExpiring)
+		fmt.Fprintf(out, "  %d  -deadline passed before every lookup finished\n", exitDeadline)
+		fmt.Fprintf(out, "  %d  interrupted by SIGINT or SIGTERM\n", exitInterrupted)
+	}
+	flag.Parse()
+
+	// Applied before the config file, so the document's options take precedence over it
+	if *jsonInputMode {
+		input, err := readJSONInput(os.Stdin)
+		if err == nil {
+			err = input.apply()
+		}
+		if err != nil {
+			fmt.Printf("Error: Invalid -json-input document: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	if path := configPath(*configFile); path != "" {
+		if err := applyConfig(path); err != nil {
+			fmt.Printf("Error reading config file: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	if *showVersion {
+		fmt.Printf("ip2whois %s (commit %s, built %s)\n", version, commit, date)
+		os.Exit(exitOK)
+	}
+
+	// Merge domains from -d and -dL, skipping duplicates
+	var domains []string
+	seen := make(map[string]bool)
+	addDomain := func(d string) {
+		if !seen[d] {
+			seen[d] = true
+			domains = append(domains, d)
+		}
+	}
+
+	for _, d := range domainArgs {
+		addDomain(d)
+	}
+
+	if *domainList != "" {
+		list, err := readLinesFile(*domainList)
+		if err != nil {
+			fmt.Printf("Error reading domain list: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		for _, d := range list {
+			addDomain(d)
+		}
+	}
+
+	// An IP lookup replaces the domain inputs entirely
+	queryType := ip2whois.QueryDomain
+	if *ipAddress != "" || *cidrBlock != "" {
+		if len(domains) > 0 || *etld || *followCNAMEs || (*ipAddress != "" && *cidrBlock != "") {
+			fmt.Println("Error: The -ip and -cidr flags cannot be combined with each other or with -d, -dL, -etld or -follow-cname.")
+			os.Exit(exitUsage)
+		}
+		queryType = ip2whois.QueryIP
+	}
+	if *ipAddress != "" {
+		addr, err := parseIP(*ipAddress)
+		if err != nil {
+			fmt.Printf("Error: %q is not a valid IPv4 or IPv6 address.\n", *ipAddress)
+			os.Exit(exitUsage)
+		}
+		domains = append(domains, addr.String())
+	}
+	if *cidrBlock != "" {
+		hosts, err := expandCIDR(*cidrBlock, *confirm)
+		if err != nil {
+			fmt.Printf("Error: Invalid -cidr block: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		domains = append(domains, hosts...)
+	}
+
+	// Fall back to reading domains from stdin when it is piped
+	useStdin := !*jsonInputMode && len(domainArgs) == 0 && *domainList == "" && *ipAddress == "" && *cidrBlock == "" && *serveAddr == "" && !*checkKeysMode && stdinIsPiped()
+
+	// Ensure at least one domain is provided
+	if len(domains) == 0 && !useStdin && *serveAddr == "" && !*checkKeysMode {
+		fmt.Println("Error: Domain (-d) or domain list (-dL) flag is required.")
+		os.Exit(exitUsage)
+	}
+
+	// Raw output is never parsed, so it can't be cleaned or filtered
+	// -clean bundles the three toggles, and -keep-empty overrides the empty and null ones
+	clean := ip2whois.CleanOptions{
+		Words:        splitList(*cleanWords),
+		DropRedacted: *hideRedacted || *dropRedacted,
+		DropEmpty:    (*hideRedacted || *dropEmpty) && !*keepEmpty,
+		DropNull:     (*hideRedacted || *dropNull) && !*keepEmpty,
+	}
+	if *keepEmpty && (*dropEmpty || *dropNull) {
+		fmt.Println("Error: The -keep-empty flag cannot be combined with -drop-empty or -drop-null.")
+		os.Exit(exitUsage)
+	}
+
+	cleaning := clean.DropRedacted || clean.DropEmpty || clean.DropNull
+	if (*raw || *whoisText) && (cleaning || *keepEmpty || *fields != "" || *fieldsExclude != "" || *expiry || *sortKeys || *normalizeNS || *normalizeContacts || *renameFile != "" || *includeRaw || *flatten) {
+		fmt.Println("Error: The -raw and -whois-text flags cannot be combined with -clean, -drop-*, -keep-empty, -fields, -fields-exclude, -expiry, -sort-keys, -normalize-ns, -normalize-contacts, -rename, -include-raw or -flatten.")
+		os.Exit(exitUsage)
+	}
+
+	if *renameStrict && *renameFile == "" {
+		fmt.Println("Error: The -rename-strict flag requires -rename.")
+		os.Exit(exitUsage)
+	}
+	var renames []fieldRename
+	if *renameFile != "" {
+		loaded, err := loadRenames(*renameFile)
+		if err != nil {
+			fmt.Printf("Error reading rename file: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		renames = loaded
+	}
+
+	if *flattenArrays != "index" && *flattenArrays != "join" {
+		fmt.Printf("Error: Unknown -flatten-arrays mode %q; use index or join.\n", *flattenArrays)
+		os.Exit(exitUsage)
+	}
+
+	formats := 0
+	for _, set := range []bool{*raw, *whoisText, *ndjson, *jsonArray, *csvOutput, *tableOutput, *yamlOutput, *compareFile != "", *queryExpr != "", *templateText != "", *abuse} {
+		if set {
+			formats++
+		}
+	}
+	if formats > 1 {
+		fmt.Println("Error: Only one of -raw, -whois-text, -ndjson, -json-array, -csv, -table, -yaml, -compare, -query, -template and -abuse can be used.")
+		os.Exit(exitUsage)
+	}
+
+	if *whoisText && (queryType == ip2whois.QueryIP || *expiringIn >= 0 || *createdIn >= 0 || *onlyRegistered || *onlyAvailable || *statuses != "" || *registrar != "") {
+		fmt.Println("Error: The -whois-text flag only applies to domain lookups, without -expiring-in, -created-within, -status, -registrar or -only-* filters.")
+		os.Exit(exitUsage)
+	}
+
+	// The server answers one domain per request with JSON, so batch options don't apply
+	if *serveAddr != "" && (len(domains) > 0 || queryType == ip2whois.QueryIP || (formats > 0 && !*raw) ||
+		*outputFile != "" || *resumeFile != "" || *failOut != "" || *dryRunMode ||
+		*expiringIn >= 0 || *createdIn >= 0 || *onlyRegistered || *onlyAvailable || *statuses != "" || *registrar != "") {
+		fmt.Println("Error: The -serve flag cannot be combined with domain or IP inputs, output formats other than -raw, -o, -resume, -fail-out, -dry-run or filters.")
+		os.Exit(exitUsage)
+	}
+
+	if *checkKeysMode && (len(domains) > 0 || queryType == ip2whois.QueryIP || *serveAddr != "" || *dryRunMode) {
+		fmt.Println("Error: The -check-keys flag takes no domain or IP inputs and cannot be combined with -serve or -dry-run.")
+		os.Exit(exitUsage)
+	}
+
+	if *watchEvery < 0 {
+		fmt.Println("Error: The -watch interval cannot be negative.")
+		os.Exit(exitUsage)
+	}
+	if *watchEvery > 0 && (queryType == ip2whois.QueryIP || formats > 0 || *serveAddr != "" || *resumeFile != "" || *failOut != "" ||
+		*dryRunMode || *expiringIn >= 0 || *createdIn >= 0 || *onlyRegistered || *onlyAvailable || *statuses != "" || *registrar != "") {
+		fmt.Println("Error: The -watch flag cannot be combined with IP inputs, output formats, -serve, -resume, -fail-out, -dry-run or filters.")
+		os.Exit(exitUsage)
+	}
+
+	sampling := *sampleCount > 0 || *samplePct > 0
+	if *sampleCount < 0 || *samplePct < 0 || *samplePct > 100 || (*sampleCount > 0 && *samplePct > 0) {
+		fmt.Println("Error: Use either -sample with a positive count or -sample-pct with a percentage between 0 and 100.")
+		os.Exit(exitUsage)
+	}
+	if sampling && (queryType == ip2whois.QueryIP || *serveAddr != "" || *watchEvery > 0 || *checkKeysMode) {
+		fmt.Println("Error: The -sample and -sample-pct flags cannot be combined with IP inputs, -serve, -watch or -check-keys.")
+		os.Exit(exitUsage)
+	}
+	if *sampleSeed != 0 && !sampling {
+		fmt.Println("Error: The -seed flag requires -sample or -sample-pct.")
+		os.Exit(exitUsage)
+	}
+
+	var compiledQuery query
+	if *queryExpr != "" {
+		q, err := compileQuery(*queryExpr)
+		if err != nil {
+			fmt.Printf("Error: Invalid -query expression: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		compiledQuery = q
+	}
+
+	var tmpl *template.Template
+	if *templateText != "" {
+		t, err := parseTemplate(*templateText)
+		if err != nil {
+			fmt.Printf("Error: Invalid -template: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		tmpl = t
+	}
+
+	var snapshots map[string]map[string]interface{}
+	if *compareFile != "" {
+		loaded, err := loadSnapshots(*compareFile)
+		if err != nil {
+			fmt.Printf("Error reading snapshot file: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		snapshots = loaded
+	}
+
+	if *apiURL != "" {
+		if u, err := url.Parse(*apiURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fmt.Printf("Error: %q is not a valid http or https API URL.\n", *apiURL)
+			os.Exit(exitUsage)
+		}
+	}
+
+	if u, err := url.Parse(*rdapURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fmt.Printf("Error: %q is not a valid http or https RDAP URL.\n", *rdapURL)
+		os.Exit(exitUsage)
+	}
+
+	// The provider chain, in the order providers are tried
+	if *direct {
+		if *providerName != "ip2whois" && *providerName != "whois" {
+			fmt.Println("Error: The -direct flag cannot be combined with another -provider.")
+			os.Exit(exitUsage)
+		}
+		*providerName = "whois"
+	}
+	chainNames := append([]string{*providerName}, splitList(*fallback)...)
+	usesIP2Whois := false
+	for i, name := range chainNames {
+		switch name {
+		case "ip2whois":
+			usesIP2Whois = true
+		case "rdap", "whois":
+		default:
+			fmt.Printf("Error: Unknown provider %q; use ip2whois, rdap or whois.\n", name)
+			os.Exit(exitUsage)
+		}
+		for _, earlier := range chainNames[:i] {
+			if earlier == name {
+				fmt.Printf("Error: Provider %q is listed more than once in -provider and -fallback.\n", name)
+				os.Exit(exitUsage)
+			}
+		}
+	}
+	if queryType == ip2whois.QueryIP && (len(chainNames) > 1 || *providerName != "ip2whois") {
+		fmt.Println("Error: IP lookups are only supported by the ip2whois provider, without -fallback.")
+		os.Exit(exitUsage)
+	}
+
+	if *rdapFallback && (*providerName == "rdap" || queryType == ip2whois.QueryIP) {
+		fmt.Println("Error: The -rdap-fallback flag only applies to domain lookups with another -provider.")
+		os.Exit(exitUsage)
+	}
+
+	switch *logFormat {
+	case "text":
+	case "json":
+		useJSONLog()
+	default:
+		fmt.Printf("Error: Unknown -log-format %q; use text or json.\n", *logFormat)
+		os.Exit(exitUsage)
+	}
+
+	if *onlyRegistered && *onlyAvailable {
+		fmt.Println("Error: The -only-registered and -only-available flags cannot be combined.")
+		os.Exit(exitUsage)
+	}
+	if queryType == ip2whois.QueryIP && (*onlyRegistered || *onlyAvailable) {
+		fmt.Println("Error: The -only-registered and -only-available flags only apply to domain lookups.")
+		os.Exit(exitUsage)
+	}
+
+	if quiet && *verbose {
+		fmt.Println("Error: The -q and -v flags cannot be combined.")
+		os.Exit(exitUsage)
+	}
+
+	var proxyURL *url.URL
+	if *proxy != "" {
+		u, err := url.Parse(*proxy)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+			fmt.Printf("Error: %q is not a valid http, https or socks5 proxy URL.\n", *proxy)
+			os.Exit(exitUsage)
+		}
+		proxyURL = u
+	}
+
+	if *rps < 0 {
+		fmt.Println("Error: The request rate (-rps) cannot be negative.")
+		os.Exit(exitUsage)
+	}
+
+	if *perKey < 0 {
+		fmt.Println("Error: The per-key concurrency (-per-key-concurrency) cannot be negative.")
+		os.Exit(exitUsage)
+	}
+	if *perDomainTimeout < 0 {
+		fmt.Println("Error: The -per-domain-timeout cannot be negative.")
+		os.Exit(exitUsage)
+	}
+	if *minSuccess != -1 && (*minSuccess < 0 || *minSuccess > 100) {
+		fmt.Println("Error: The -min-success percentage must be between 0 and 100.")
+		os.Exit(exitUsage)
+	}
+	if *batchSize < 0 || *batchPause < 0 {
+		fmt.Println("Error: The -batch-size and -batch-pause values cannot be negative.")
+		os.Exit(exitUsage)
+	}
+	if *batchPause > 0 && *batchSize == 0 {
+		fmt.Println("Error: The -batch-pause flag requires -batch-size.")
+		os.Exit(exitUsage)
+	}
+	if *keyRetries < 0 || *keyCooldown < 0 {
+		fmt.Println("Error: The -key-retries count and -key-cooldown wait cannot be negative.")
+		os.Exit(exitUsage)
+	}
+
+	if *concurrency < 1 {
+		fmt.Println("Error: Concurrency (-c) must be at least 1.")
+		os.Exit(exitUsage)
+	}
+
+	// Resolve API keys: the -k flag wins, then the -kF file, then the environment
+	var keys []string
+	switch {
+	case *apiKeys != "":
+		keys = splitList(*apiKeys)
+	case *keyFile != "":
+		list, err := readLinesFile(*keyFile)
+		if err != nil {
+			fmt.Printf("Error reading key file: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		keys = list
+	default:
+		keys = splitList(os.Getenv(apiKeyEnv))
+	}
+
+	// Without keys, -free swaps the API for the providers that need none
+	freePath := false
+	if *free && len(keys) == 0 && usesIP2Whois && !*whoisText {
+		if queryType == ip2whois.QueryIP {
+			fmt.Println("Error: The -free flag only supports domain lookups.")
+			os.Exit(exitUsage)
+		}
+		var names []string
+		listed := make(map[string]bool)
+		for _, name := range append(chainNames, "rdap", "whois") {
+			if name != "ip2whois" && !listed[name] {
+				listed[name] = true
+				names = append(names, name)
+			}
+		}
+		chainNames, usesIP2Whois, freePath = names, false, true
+		// RDAP is already in the chain
+		*rdapFallback = false
+
+		logf("warning: no API keys given; -free looks domains up with public RDAP and WHOIS servers (%s) instead.", strings.Join(chainNames, ", "))
+		logf("warning: free results are best-effort, often lack contact details, and those servers rate limit aggressively; lower -c if lookups fail.")
+	} else if *free && len(keys) > 0 {
+		logf("-free is ignored as API keys were given")
+	}
+
+	// Ensure API keys are provided when the ip2whois provider may be used
+	if len(keys) == 0 && (usesIP2Whois && !*whoisText || *checkKeysMode) {
+		fmt.Printf("Error: API keys (-k), a key file (-kF) or the %s environment variable is required (or -free for best-effort lookups without one).\n", apiKeyEnv)
+		os.Exit(exitUsage)
+	}
+
+	if *appendOutput && *outputFile == "" {
+		fmt.Println("Error: The -append flag requires an output file (-o).")
+		os.Exit(exitUsage)
+	}
+	if *gzipOutput {
+		if *outputFile == "" {
+			fmt.Println("Error: The -gzip flag requires an output file (-o).")
+			os.Exit(exitUsage)
+		}
+		if !strings.HasSuffix(*outputFile, ".gz") {
+			*outputFile += ".gz"
+			logf("Writing gzipped output to %s", *outputFile)
+		}
+	}
+
+	client := ip2whois.NewClient(keys...)
+	client.Retries = *retries
+	client.UserAgent = *userAgent
+	client.Header = http.Header(headers)
+	client.MaxResponseSize = int64(maxSize)
+	// Only -raw and -include-raw print the response bytes
+	client.DiscardBody = !*raw && !*whoisText && !*includeRaw
+	client.RoundRobin = *rotate
+	client.PerKeyConcurrency = *perKey
+	client.KeyRounds = *keyRetries
+	client.KeyCooldown = *keyCooldown
+	if *rps > 0 {
+		client.Limiter = ip2whois.NewRateLimiter(*rps)
+	}
+	client.Logf = logf
+	// -q silences notices, which logf would otherwise do for the client
+	if jsonLog != nil && !quiet {
+		client.Logger = jsonLog
+	}
+	summary := newRunSummary()
+	stats := &requestStats{}
+	client.OnAttempt = func(attempt ip2whois.Attempt) {
+		summary.attempt(attempt)
+		stats.attempt(attempt)
+		if *verbose {
+			logAttempt(attempt)
+		}
+	}
+
+	if *apiURL != "" {
+		if queryType == ip2whois.QueryIP {
+			client.IPBaseURL = *apiURL
+		} else {
+			client.BaseURL = *apiURL
+		}
 	}
-	defer resp.Body.Close()
 
-	// Check for non-200 status code
-	if resp.StatusCode != 200 {
-		return "", errors.New(fmt.Sprintf("Error: Received status code %d", resp.StatusCode))
+	// Route requests through an explicit proxy instead of the environment's
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxyURL != nil {
+		transport.Proxy = http.ProxyURL(proxyURL)
 	}
 
-	// Read response body
-	body, err := ioutil.ReadAll(resp.Body)
+	tlsConf, err := tlsConfig(*caCert, *insecure)
 	if err != nil {
-		return "", err
+		fmt.Printf("Error loading CA certificate: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	if tlsConf != nil {
+		transport.TLSClientConfig = tlsConf
+	}
+	if *insecure {
+		logError("WARNING: TLS certificate verification is disabled (-insecure); API keys and responses can be intercepted")
 	}
 
-	// Parse the response to check if it contains an error
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", err
+	// The timeout covers the whole request, including reading the body
+	client.HTTPClient = &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(*timeout) * time.Second,
+	}
+	if !*followRedirects {
+		client.HTTPClient.CheckRedirect = ip2whois.CheckSameHostRedirect
 	}
 
-	if _, ok := result["error"]; ok {
-		return "", errors.New("API key failed: error in response")
+	// RDAP requests carry no key, and rdap.org redirects to the registry's
+	// server, so the -H gateway headers are not sent along
+	rdap := ip2whois.NewRDAP()
+	rdap.BaseURL = *rdapURL
+	rdap.HTTPClient = &http.Client{
+		Transport: transport,
+		Timeout:   client.HTTPClient.Timeout,
 	}
+	rdap.UserAgent = *userAgent
+	rdap.MaxResponseSize = int64(maxSize)
 
-	return string(body), nil
-}
+	whois := ip2whois.NewWHOIS()
+	whois.Server = *whoisServer
+	whois.Timeout = time.Duration(*timeout) * time.Second
+	whois.MaxResponseSize = int64(maxSize)
+
+	chain := &ip2whois.Fallback{Logf: logf}
+	for _, name := range chainNames {
+		switch name {
+		case "rdap":
+			chain.Providers = append(chain.Providers, rdap)
+		case "whois":
+			chain.Providers = append(chain.Providers, whois)
+		default:
+			chain.Providers = append(chain.Providers, client)
+		}
+	}
+
+	var provider ip2whois.Provider = chain
+	if *rdapFallback {
+		provider = &ip2whois.Supplement{Primary: chain, Secondary: rdap, Logf: logf}
+	}
 
-// Recursively filter out fields that contain the word "REDACTED" or are empty
-func removeRedactedAndEmptyFields(data map[string]interface{}) map[string]interface{} {
-	cleaned := make(map[string]interface{})
+	// Work out the value each input is queried as
+	normalize := func(d string) (string, error) {
+		if queryType == ip2whois.QueryIP {
+			return d, nil
+		}
+		normalized, err := ip2whois.NormalizeDomain(d)
+		if err != nil || !*etld {
+			return normalized, err
+		}
+		return ip2whois.RegistrableDomain(normalized)
+	}
 
-	for key, value := range data {
-		switch v := value.(type) {
-		case string:
-			// If the value is a string, check if it contains "REDACTED" or if it's empty
-			if v != "" && !strings.Contains(v, "REDACTED") {
-				cleaned[key] = v
+	// Collapse inputs that normalize to an earlier one, such as a URL and its
+	// bare domain, so each is looked up and output once. Invalid inputs are
+	// kept to fail as usual.
+	queried := make(map[string]bool)
+	collapsed := 0
+	duplicate := func(d string) bool {
+		if !*dedupe {
+			return false
+		}
+		value, err := normalize(d)
+		if err != nil {
+			return false
+		}
+		if queried[value] {
+			collapsed++
+			return true
+		}
+		queried[value] = true
+		return false
+	}
+	collapse := func(list []string) []string {
+		var kept []string
+		for _, d := range list {
+			if !duplicate(d) {
+				kept = append(kept, d)
 			}
-		case map[string]interface{}:
-			// Recursively clean nested objects
-			cleanedNested := removeRedactedAndEmptyFields(v)
-			if len(cleanedNested) > 0 {
-				cleaned[key] = cleanedNested
+		}
+		return kept
+	}
+	reportCollapsed := func() {
+		if collapsed > 0 {
+			logf("Collapsed %d duplicate input(s) that normalize to an earlier domain", collapsed)
+		}
+	}
+
+	if *checkKeysMode {
+		unusable, err := checkKeys(context.Background(), os.Stdout, client)
+		if err != nil {
+			logError("Error: %v", err)
+			os.Exit(exitUsage)
+		}
+		if unusable > 0 {
+			logError("\n%d of %d key(s) cannot be used", unusable, len(keys))
+			os.Exit(exitLookupFailed)
+		}
+		os.Exit(exitOK)
+	}
+
+	// A sample is drawn from the whole input, so stdin can't be streamed
+	if sampling && useStdin {
+		if err := readLines(os.Stdin, addDomain); err != nil {
+			fmt.Printf("Error reading domains from stdin: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		useStdin = false
+	}
+	sample := func(list []string) []string {
+		if !sampling {
+			return list
+		}
+		seed := *sampleSeed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		n := sampleSize(len(list), *sampleCount, *samplePct)
+		logf("Sampling %d of %d domain(s) with -seed %d", n, len(list), seed)
+		return sampleDomains(list, n, seed)
+	}
+
+	if *dryRunMode {
+		if useStdin {
+			if err := readLines(os.Stdin, addDomain); err != nil {
+				fmt.Printf("Error reading domains from stdin: %v\n", err)
+				os.Exit(exitUsage)
 			}
-		case []interface{}:
-			// Handle arrays, remove if they are empty
-			if len(v) > 0 {
-				cleaned[key] = v
+		}
+		domains = sample(collapse(domains))
+		reportCollapsed()
+		// -whois-text goes straight to port 43, whatever the provider chain
+		primary := chain.Providers[0]
+		if *whoisText {
+			primary = whois
+		}
+		dryRun(os.Stdout, client, primary, queryType, domains, normalize)
+		os.Exit(exitOK)
+	}
+
+	// Open the output file before making any API calls
+	// Stdout may share the terminal with the progress bar
+	var out io.Writer = barWriter{os.Stdout}
+	closeOutput := func() error { return nil }
+	if *outputFile != "" {
+		mode := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if *appendOutput {
+			mode = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+
+		file, err := os.OpenFile(*outputFile, mode, 0644)
+		if err != nil {
+			fmt.Printf("Error opening output file: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		defer file.Close()
+		out = file
+
+		// Appending adds a gzip member, which gzip readers decompress as one stream
+		if *gzipOutput {
+			compressed := gzip.NewWriter(file)
+			out = compressed
+			closeOutput = compressed.Close
+		}
+	}
+
+	// Truncate the retry file up front so a stale list never survives a run
+	if *failOut != "" {
+		if err := writeLinesFile(*failOut, nil); err != nil {
+			fmt.Printf("Error opening failed domains file: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	var resume *checkpoint
+	if *resumeFile != "" {
+		cp, err := openCheckpoint(*resumeFile)
+		if err != nil {
+			fmt.Printf("Error opening checkpoint file: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		defer cp.close()
+		resume = cp
+	}
+
+	if *cacheDir != "" {
+		cache, err := ip2whois.NewCache(*cacheDir, *cacheTTL)
+		if err != nil {
+			fmt.Printf("Error creating cache directory: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		client.Cache = cache
+	}
+
+	lookup := provider.Lookup
+	if *whoisText {
+		lookup = func(ctx context.Context, d string) (*ip2whois.Record, error) {
+			responses, err := whois.Query(ctx, d)
+			if err != nil {
+				return nil, err
 			}
-		default:
-			// Keep other data types (numbers, booleans, etc.) but remove `null` values
-			if v != nil {
-				cleaned[key] = v
+			// Query succeeding means the name is valid
+			name, _ := ip2whois.NormalizeDomain(d)
+			return whoisTextRecord(name, responses), nil
+		}
+	}
+	if queryType == ip2whois.QueryIP {
+		lookup = client.LookupIP
+	} else if *followCNAMEs {
+		// The full name is resolved, so reduction to eTLD+1 happens afterwards
+		lookup = followCNAME(lookup, *etld)
+	} else if *etld {
+		base := lookup
+		lookup = func(ctx context.Context, d string) (*ip2whois.Record, error) {
+			registrable, err := normalize(d)
+			if err != nil {
+				return nil, err
 			}
+			return base(ctx, registrable)
 		}
 	}
 
-	return cleaned
-}
+	// Bound the time one stubborn domain can take, while -deadline bounds the run
+	if *perDomainTimeout > 0 {
+		bounded := lookup
+		lookup = func(ctx context.Context, d string) (*ip2whois.Record, error) {
+			domainCtx, cancel := context.WithTimeout(ctx, *perDomainTimeout)
+			defer cancel()
+			record, err := bounded(domainCtx, d)
+			if err != nil && ctx.Err() == nil && domainCtx.Err() != nil {
+				return nil, fmt.Errorf("%w: gave up after %s (-per-domain-timeout)", ip2whois.ErrTimeout, *perDomainTimeout)
+			}
+			return record, err
+		}
+	}
 
-func main() {
-	// Command line flags
-	apiKeys := flag.String("k", "", "Comma-separated list of API keys for ip2whois")
-	domain := flag.String("d", "", "Domain to fetch the whois information for")
-	hideRedacted := flag.Bool("clean", false, "Hide fields containing the word 'REDACTED' and empty fields")
-	flag.Parse()
+	started := time.Now()
+
+	// Lookups run under ctx, while new ones only start until feedCtx is done
+	ctx, abort := context.WithCancel(context.Background())
+	defer abort()
 
-	// Ensure a domain is provided
-	if *domain == "" {
-		fmt.Println("Error: Domain (-d) flag is required.")
-		os.Exit(1)
+	// The deadline abandons queued and in-flight lookups alike
+	if *deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *deadline)
+		defer cancel()
 	}
 
-	// Ensure API keys are provided
-	if *apiKeys == "" {
-		fmt.Println("Error: API keys (-k) flag is required.")
-		os.Exit(1)
+	feedCtx, drain := context.WithCancel(ctx)
+	defer drain()
+	interrupted := handleInterrupts(drain, abort)
+
+	opts := outputOptions{
+		clean:       clean,
+		keepEmpty:   *keepEmpty,
+		includeRaw:  *includeRaw,
+		fields:      splitList(*fields),
+		exclude:     splitList(*fieldsExclude),
+		flatten:     *flatten,
+		joinArrays:  *flattenArrays == "join",
+		expiry:      *expiry,
+		sortLists:   *sortKeys,
+		normalizeNS: *normalizeNS,
+		contacts:    *normalizeContacts,
+		available:   queryType == ip2whois.QueryDomain,
+		free:        freePath,
+		renames:     renames,
+		renameOnly:  *renameStrict,
 	}
 
-	// Split the keys by comma into a slice
-	keys := strings.Split(*apiKeys, ",")
+	// Accept a literal \t so tabs can be given without shell quoting tricks
+	jsonIndent := strings.Replace(*indent, `\t`, "\t", -1)
 
-	// Try each API key until one works
-	var success bool
-	for _, key := range keys {
-		response, err := fetchIP2Whois(strings.TrimSpace(key), *domain)
-		if err == nil {
+	if *serveAddr != "" {
+		s := &server{lookup: lookup, normalize: normalize, opts: opts, raw: *raw, indent: jsonIndent}
+		if err := s.run(feedCtx, *serveAddr); err != nil {
+			logError("Error: %v", err)
+			os.Exit(exitUsage)
+		}
+		os.Exit(exitOK)
+	}
 
-			var jsonData map[string]interface{}
-			if err := json.Unmarshal([]byte(response), &jsonData); err != nil {
-				fmt.Printf("Error parsing JSON: %v\n", err)
-				os.Exit(1)
+	if *watchEvery > 0 {
+		if useStdin {
+			if err := readLines(os.Stdin, addDomain); err != nil {
+				fmt.Printf("Error reading domains from stdin: %v\n", err)
+				os.Exit(exitUsage)
 			}
+		}
+		w := &watcher{lookup: lookup, normalize: normalize, concurrency: *concurrency, out: out}
+		domains = collapse(domains)
+		reportCollapsed()
+		w.run(feedCtx, domains, *watchEvery)
+		if err := closeOutput(); err != nil {
+			logError("Error writing output: %v", err)
+		}
+		os.Exit(exitOK)
+	}
+
+	// Color only makes sense on a terminal, never in an -o file
+	color := *outputFile == "" && useColor(*noColor)
+
+	var writer recordWriter
+	switch {
+	case *raw, *whoisText:
+		writer = &rawWriter{w: out}
+	case *jsonArray:
+		writer = &jsonArrayWriter{w: out, indent: jsonIndent}
+	case *csvOutput:
+		writer = newCSVWriter(out, *flatten)
+	case *tableOutput:
+		writer = newTableWriter(out, color)
+	case *yamlOutput:
+		writer = &yamlWriter{w: out}
+	case tmpl != nil:
+		writer = &templateWriter{w: out, tmpl: tmpl}
+	case compiledQuery != nil:
+		writer = &queryWriter{w: out, q: compiledQuery}
+	case *abuse:
+		writer = &abuseWriter{w: out}
+	case snapshots != nil:
+		writer = &compareWriter{w: out, snapshots: snapshots}
+	default:
+		writer = &jsonWriter{w: out, ndjson: *ndjson, indent: jsonIndent, color: color}
+	}
+
+	var filters []recordFilter
+	if *expiringIn >= 0 {
+		filters = append(filters, expiringWithin(*expiringIn))
+	}
+	if *createdIn >= 0 {
+		filters = append(filters, createdWithin(*createdIn))
+	}
+	if *onlyRegistered || *onlyAvailable {
+		filters = append(filters, availability(*onlyAvailable))
+	}
+	if list := splitList(*statuses); len(list) > 0 {
+		filters = append(filters, statusIn(list))
+	}
+	if *registrar != "" {
+		filters = append(filters, registrarContains(*registrar))
+	}
+
+	// Start the workers; the client is safe to share between them
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		inFlight  sync.WaitGroup // jobs sent but not yet finished, for -batch-size
+		prog      *progress      // set before the first job is sent, if shown
+		failed    []string
+		unknown   []string
+		retryable []string
+		emitted   int
+		completed int
+		aborted   int
+	)
+	jobs := make(chan string)
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var d string
+				select {
+				case next, ok := <-jobs:
+					if !ok {
+						return
+					}
+					d = next
+				case <-feedCtx.Done():
+					return
+				}
+				// Both cases may be ready at once; don't start a lookup after an interrupt
+				if feedCtx.Err() != nil {
+					inFlight.Done()
+					return
+				}
+
+				var (
+					jsonData  map[string]interface{}
+					keep      bool
+					filterErr error
+				)
+				record, err := lookup(ctx, d)
+
+				// An unregistered domain is an answer rather than a failure
+				if errors.Is(err, ip2whois.ErrDomainNotFound) && queryType == ip2whois.QueryDomain {
+					name, normErr := normalize(d)
+					if normErr != nil {
+						name = d
+					}
+					record, err = availableRecord(name), nil
+				}
+
+				// Catch the API changing its response format before consumers do
+				if err == nil && (*validate || *strict) && queryType == ip2whois.QueryDomain && !isAvailable(record) {
+					if violations := validateResponse(record.Raw); len(violations) > 0 {
+						if *strict {
+							err = fmt.Errorf("response doesn't match the expected schema: %s", strings.Join(violations, "; "))
+						} else {
+							logf("%s: warning: response doesn't match the expected schema: %s", d, strings.Join(violations, "; "))
+						}
+					}
+				}
+
+				if err == nil {
+					if *showCredits && record.Provider == client.Name() && !record.Cached && !isAvailable(record) {
+						logCredits(d, record)
+					}
 
-			if *hideRedacted {
-				// Remove redacted and empty fields if the flag is set
-				jsonData = removeRedactedAndEmptyFields(jsonData)
+					keep, filterErr = applyFilters(filters, record)
+					if keep && !*raw && !*whoisText {
+						jsonData = prepareRecord(d, record, opts)
+					}
+				}
+
+				// Serialize output so results never interleave
+				mu.Lock()
+				done := err == nil
+				switch {
+				case err != nil && ctx.Err() != nil:
+					aborted++
+				case err != nil:
+					logFailure(d, err)
+					if *explain {
+						logList("Why "+d+" failed", explainFailure(err))
+					}
+					failed = append(failed, fmt.Sprintf("%s: %v", d, err))
+					summary.failure(err)
+					// Another run can't fix a malformed or unregistered domain
+					if !errors.Is(err, ip2whois.ErrInvalidDomain) && !errors.Is(err, ip2whois.ErrDomainNotFound) {
+						retryable = append(retryable, d)
+					}
+					if ew, ok := writer.(errorWriter); ok {
+						if err := ew.writeError(d, err); err != nil {
+							logf("%s: %v", d, err)
+						}
+					}
+					completed++
+				case filterErr != nil:
+					unknown = append(unknown, fmt.Sprintf("%s: %v", d, filterErr))
+					completed++
+				case keep:
+					if err := writer.write(d, record, jsonData); err != nil {
+						logFailure(d, err)
+						failed = append(failed, fmt.Sprintf("%s: %v", d, err))
+						summary.failure(fmt.Errorf("output: %w", err))
+						done = false
+					} else {
+						emitted++
+					}
+					completed++
+				default:
+					completed++
+				}
+
+				// Checkpoint successful lookups once their output is written
+				if resume != nil && done {
+					if err := resume.record(d); err != nil {
+						logf("%s: warning: cannot write checkpoint: %v", d, err)
+					}
+				}
+				mu.Unlock()
+				if prog != nil {
+					prog.advance()
+				}
+				inFlight.Done()
 			}
+		}()
+	}
 
-			// Print the cleaned JSON
-			cleanedOutput, err := json.MarshalIndent(jsonData, "", "  ")
-			if err != nil {
-				fmt.Printf("Error formatting JSON: %v\n", err)
-				os.Exit(1)
+	// Wait for the current batch to finish, then pause before the next one
+	sent := 0
+	nextBatch := func() bool {
+		idle := make(chan struct{})
+		go func() {
+			inFlight.Wait()
+			close(idle)
+		}()
+		select {
+		case <-idle:
+		case <-feedCtx.Done():
+			return false
+		}
+
+		batch := sent / *batchSize
+		if *batchPause > 0 {
+			logf("Batch %d done (%d domain(s) so far), pausing for %s", batch, sent, *batchPause)
+			select {
+			case <-time.After(*batchPause):
+			case <-feedCtx.Done():
+				return false
+			}
+		} else {
+			logf("Batch %d done (%d domain(s) so far)", batch, sent)
+		}
+		logf("Starting batch %d", batch+1)
+		return true
+	}
+
+	// Feed the workers until the input runs out or the run is cancelled
+	send := func(d string) bool {
+		if *batchSize > 0 && sent > 0 && sent%*batchSize == 0 && !nextBatch() {
+			return false
+		}
+		inFlight.Add(1)
+		select {
+		case jobs <- d:
+			sent++
+			return true
+		case <-feedCtx.Done():
+			inFlight.Done()
+			return false
+		}
+	}
+
+	// Skip domains a previous run with the same checkpoint completed
+	resumed := 0
+	pending := func(d string) bool {
+		if resume != nil && resume.completed(d) {
+			resumed++
+			return false
+		}
+		return true
+	}
+
+	// Count the listed domains this run will process, for the interrupt summary
+	domains = sample(collapse(domains))
+	listed := 0
+	for _, d := range domains {
+		if resume == nil || !resume.completed(d) {
+			listed++
+		}
+	}
+
+	// Streamed input has no known total, but may still be long
+	if !quiet && !*noProgress && (listed > 1 || useStdin) {
+		total := listed
+		if useStdin {
+			total = 0
+		}
+		prog = startProgress(total)
+	}
+
+	readErrs := make(chan error, 1)
+	go func() {
+		defer close(jobs)
+		for _, d := range domains {
+			if !pending(d) {
+				continue
+			}
+			if !send(d) {
+				readErrs <- nil
+				return
 			}
+		}
+
+		var err error
+		if useStdin {
+			// Stream domains from stdin as they arrive, skipping duplicates
+			err = readLines(os.Stdin, func(d string) {
+				if !seen[d] {
+					seen[d] = true
+					if !duplicate(d) && pending(d) {
+						send(d)
+					}
+				}
+			})
+		}
+		reportCollapsed()
+		if resumed > 0 {
+			logf("Skipped %d domain(s) already completed in %s", resumed, *resumeFile)
+		}
+		readErrs <- err
+	}()
+
+	// Workers return early on cancellation, so don't wait on a feeder blocked reading stdin
+	wg.Wait()
+	if prog != nil {
+		prog.finish()
+	}
+
+	if err := writer.close(); err != nil {
+		logError("Error writing output: %v", err)
+	}
+	// Exits skip deferred calls, so the gzip trailer is written here
+	if err := closeOutput(); err != nil {
+		logError("Error writing output: %v", err)
+	}
+
+	if *failOut != "" {
+		if err := writeLinesFile(*failOut, retryable); err != nil {
+			logError("Error writing failed domains: %v", err)
+		} else if len(retryable) > 0 {
+			logf("Wrote %d failed domain(s) to %s", len(retryable), *failOut)
+		}
+	}
 
-			fmt.Println(string(cleanedOutput))
-			success = true
-			break
+	// Batch runs end with a breakdown that is quicker to scan than the per-domain lines
+	if completed > 1 {
+		summary.print(completed, len(failed))
+	}
+	if *showStats {
+		stats.print()
+	}
+	if *metricsFile != "" {
+		err := writeMetrics(*metricsFile, runMetrics{
+			total:    completed,
+			failed:   len(failed),
+			reasons:  summary.reasonCounts(),
+			credits:  client.Credits(),
+			duration: time.Since(started),
+			finished: time.Now(),
+		})
+		if err != nil {
+			logError("Error writing metrics file: %v", err)
+		}
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		logError("\nDeadline of %s reached: %d domain(s) completed (%d failed), %d in-flight lookup(s) aborted", *deadline, completed, len(failed), aborted)
+		os.Exit(exitDeadline)
+	}
+	if interrupted.Load() {
+		remaining := "the rest of stdin was not read"
+		if !useStdin {
+			remaining = fmt.Sprintf("%d domain(s) not started", listed-completed-aborted)
+		}
+		logError("\nInterrupted: %d domain(s) completed (%d failed), %d in-flight lookup(s) aborted, %s", completed, len(failed), aborted, remaining)
+		os.Exit(exitInterrupted)
+	}
+
+	if readErr := <-readErrs; readErr != nil {
+		fmt.Printf("Error reading domains from stdin: %v\n", readErr)
+		os.Exit(exitUsage)
+	}
+
+	// Domains the filters couldn't evaluate are neither output nor silently dropped
+	if len(unknown) > 0 && !quiet {
+		logList(fmt.Sprintf("%d domain(s) could not be filtered", len(unknown)), unknown)
+	}
+
+	// Summarize failures so pipelines can rely on the exit code alone
+	if len(failed) > 0 {
+		logList(fmt.Sprintf("%d domain(s) failed", len(failed)), failed)
+
+		// -min-success tolerates a few flaky domains in scheduled runs
+		rate := successRate(completed, len(failed))
+		if *minSuccess < 0 || rate < *minSuccess {
+			if *minSuccess >= 0 {
+				logError("\nSuccess rate %.1f%% is below -min-success %g%%", rate, *minSuccess)
+			}
+			os.Exit(exitLookupFailed)
 		}
+		logf("\nSuccess rate %.1f%% meets -min-success %g%%", rate, *minSuccess)
 	}
 
-	if !success {
-		fmt.Println("All API keys failed.")
-		os.Exit(1)
+	if *expiringIn >= 0 && emitted > 0 {
+		os.Exit(exitExpiring)
 	}
 }
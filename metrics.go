@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The figures written by -metrics-file at the end of a run
+type runMetrics struct {
+	total, failed int
+	reasons       map[string]int      // failures by failureReason
+	credits       map[int]interface{} // last reported balance by key index
+	duration      time.Duration
+	finished      time.Time
+}
+
+// Write the metrics in the Prometheus text format. The file is replaced
+// atomically, so a textfile collector never reads a partial run.
+func writeMetrics(path string, m runMetrics) error {
+	var lines []string
+	gauge := func(name, help string) {
+		lines = append(lines, "# HELP "+name+" "+help, "# TYPE "+name+" gauge")
+	}
+
+	gauge("ip2whois_lookups", "Domains looked up in the last run.")
+	lines = append(lines, fmt.Sprintf("ip2whois_lookups %d", m.total))
+	gauge("ip2whois_lookups_succeeded", "Lookups in the last run that succeeded.")
+	lines = append(lines, fmt.Sprintf("ip2whois_lookups_succeeded %d", m.total-m.failed))
+
+	gauge("ip2whois_lookups_failed", "Lookups in the last run that failed, by reason.")
+	reasons := make([]string, 0, len(m.reasons))
+	for reason := range m.reasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		lines = append(lines, fmt.Sprintf("ip2whois_lookups_failed{reason=%q} %d", reason, m.reasons[reason]))
+	}
+
+	// Balances come from the API as numbers or numeric strings
+	gauge("ip2whois_key_credits_remaining", "Last credit balance reported for each API key, by position in the key list.")
+	indexes := make([]int, 0, len(m.credits))
+	for i := range m.credits {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		balance, err := strconv.ParseFloat(strings.TrimSpace(fmt.Sprint(m.credits[i])), 64)
+		if err != nil {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("ip2whois_key_credits_remaining{key=\"%d\"} %s", i+1, strconv.FormatFloat(balance, 'f', -1, 64)))
+	}
+
+	gauge("ip2whois_run_duration_seconds", "Wall-clock duration of the last run.")
+	lines = append(lines, fmt.Sprintf("ip2whois_run_duration_seconds %s", strconv.FormatFloat(m.duration.Seconds(), 'f', 3, 64)))
+	gauge("ip2whois_run_finished_timestamp_seconds", "Unix time the last run finished.")
+	lines = append(lines, fmt.Sprintf("ip2whois_run_finished_timestamp_seconds %d", m.finished.Unix()))
+
+	return writeLinesFile(path, lines)
+}
@@ -0,0 +1,654 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/xhzeem/ip2whois/pkg/ip2whois"
+)
+
+// Options controlling how each record's data is prepared for output
+type outputOptions struct {
+	clean       ip2whois.CleanOptions // which redacted, empty and null fields to drop
+	keepEmpty   bool                  // output selected fields that are missing as null
+	fields      []string              // dotted paths to keep, or nil for all fields
+	exclude     []string              // dotted paths to remove after selection
+	expiry      bool                  // add a days_to_expiry field, -1 when unknown
+	sortLists   bool                  // sort arrays of strings, such as nameservers
+	normalizeNS bool                  // replace nameservers with the record's normalized list
+	contacts    bool                  // give every contact block the full field set
+	available   bool                  // add an available field telling unregistered domains apart
+	includeRaw  bool                  // nest the untouched response under _raw
+	flatten     bool                  // replace nested objects with dotted keys
+	joinArrays  bool                  // with flatten, join arrays into one string instead of indexing them
+	free        bool                  // add a source field marking records from the -free providers
+	renames     []fieldRename         // -rename mappings, applied after the fields above are added
+	renameOnly  bool                  // with renames, drop the fields they don't map
+}
+
+// Apply expiry, cleaning and field selection to a record's data
+func prepareRecord(value string, record *ip2whois.Record, opts outputOptions) map[string]interface{} {
+	// Work on a copy, so the fields added below don't end up in record.Raw
+	jsonData := copyObject(record.Raw)
+
+	// Compute expiry before cleaning or projection can drop expire_date
+	expiryDays := -1
+	if opts.expiry {
+		days, err := daysToExpiry(record, time.Now())
+		if err != nil {
+			logf("%s: warning: cannot compute days to expiry: %v", value, err)
+		} else {
+			expiryDays = days
+		}
+	}
+
+	if opts.clean.DropRedacted || opts.clean.DropEmpty || opts.clean.DropNull {
+		// Remove redacted, empty or null fields as selected by the flags
+		jsonData = ip2whois.CleanFields(jsonData, opts.clean)
+	}
+
+	// Set after cleaning so a domain without nameservers still gets an empty
+	// list, typed like decoded JSON so the writers handle it as an array
+	if opts.normalizeNS {
+		nameservers := make([]interface{}, len(record.Nameservers))
+		for i, ns := range record.Nameservers {
+			nameservers[i] = ns
+		}
+		jsonData["nameservers"] = nameservers
+	}
+
+	if opts.contacts {
+		jsonData = normalizeContacts(jsonData)
+	}
+
+	if len(opts.fields) > 0 {
+		jsonData = selectFields(jsonData, opts.fields, opts.keepEmpty)
+	}
+
+	if len(opts.exclude) > 0 {
+		jsonData = excludeFields(jsonData, opts.exclude)
+	}
+
+	if opts.expiry {
+		jsonData["days_to_expiry"] = expiryDays
+	}
+
+	// An explicit -fields list only gets the tag if it asks for it
+	if opts.available && (len(opts.fields) == 0 || containsString(opts.fields, "available")) {
+		jsonData["available"] = isAvailable(record)
+	}
+
+	if opts.free {
+		source := "free"
+		if record.Provider != "" {
+			source += "/" + record.Provider
+		}
+		jsonData["source"] = source
+	}
+
+	if len(opts.renames) > 0 {
+		jsonData = renameFields(jsonData, opts.renames, opts.renameOnly)
+	}
+
+	if opts.sortLists {
+		jsonData = sortStringLists(jsonData).(map[string]interface{})
+	}
+
+	// Decode the body afresh, as jsonData may share maps with record.Raw;
+	// WHOIS text isn't JSON, so it is included as a string
+	if opts.includeRaw {
+		var original interface{}
+		if err := json.Unmarshal(record.Body, &original); err != nil {
+			original = string(record.Body)
+		}
+		jsonData["_raw"] = original
+	}
+
+	if opts.flatten {
+		jsonData = flattenObject(jsonData, opts.joinArrays)
+	}
+
+	return jsonData
+}
+
+// Flatten nested objects into a single level keyed by dotted paths, such as
+// registrar.name. Arrays become indexed keys (nameservers.0) or, with join,
+// one string of their items separated by ";". Empty objects and arrays are
+// kept as they are so no key disappears.
+func flattenObject(data map[string]interface{}, join bool) map[string]interface{} {
+	flat := make(map[string]interface{})
+	var walk func(prefix string, value interface{})
+	walk = func(prefix string, value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if len(v) == 0 && prefix != "" {
+				flat[prefix] = v
+			}
+			for key, child := range v {
+				if prefix != "" {
+					key = prefix + "." + key
+				}
+				walk(key, child)
+			}
+		case []interface{}:
+			switch {
+			case len(v) == 0:
+				flat[prefix] = v
+			case join:
+				items := make([]string, len(v))
+				for i, item := range v {
+					if s, ok := item.(string); ok {
+						items[i] = s
+					} else {
+						encoded, _ := json.Marshal(item)
+						items[i] = string(encoded)
+					}
+				}
+				flat[prefix] = strings.Join(items, ";")
+			default:
+				for i, item := range v {
+					walk(fmt.Sprintf("%s.%d", prefix, i), item)
+				}
+			}
+		default:
+			flat[prefix] = v
+		}
+	}
+	walk("", data)
+	return flat
+}
+
+// Contact blocks completed by -normalize-contacts
+var contactBlocks = []string{"registrant", "admin", "tech", "billing"}
+
+// Make every contact block an object with at least the documented contact
+// fields, filling missing and null ones with empty strings. The objects are
+// copied, as they may be shared with the record's Raw map.
+func normalizeContacts(data map[string]interface{}) map[string]interface{} {
+	var template map[string]interface{}
+	encoded, _ := json.Marshal(ip2whois.Contact{})
+	json.Unmarshal(encoded, &template)
+
+	data = copyObject(data)
+	for _, block := range contactBlocks {
+		contact := make(map[string]interface{}, len(template))
+		if existing, ok := data[block].(map[string]interface{}); ok {
+			contact = copyObject(existing)
+		}
+		for field := range template {
+			if contact[field] == nil {
+				contact[field] = ""
+			}
+		}
+		data[block] = contact
+	}
+	return data
+}
+
+// Sort every array made up only of strings, ignoring case, so output is stable
+// regardless of the order the registry returns them in. Objects and arrays
+// are copied rather than sorted in place, as they may belong to record.Raw.
+func sortStringLists(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		sorted := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			sorted[key] = sortStringLists(child)
+		}
+		return sorted
+	case []interface{}:
+		sorted := make([]interface{}, len(v))
+		strs := true
+		for i, item := range v {
+			if _, ok := item.(string); !ok {
+				strs = false
+			}
+			sorted[i] = sortStringLists(item)
+		}
+		if strs {
+			sort.SliceStable(sorted, func(i, j int) bool {
+				return strings.ToLower(sorted[i].(string)) < strings.ToLower(sorted[j].(string))
+			})
+		}
+		return sorted
+	}
+	return value
+}
+
+// Writes records in one output format; calls are serialized by the caller
+type recordWriter interface {
+	write(value string, record *ip2whois.Record, jsonData map[string]interface{}) error
+	close() error
+}
+
+// Implemented by writers that report failed lookups inline with the results
+type errorWriter interface {
+	writeError(value string, err error) error
+}
+
+// Writes the API response exactly as received
+type rawWriter struct {
+	w io.Writer
+}
+
+func (rw *rawWriter) write(value string, record *ip2whois.Record, jsonData map[string]interface{}) error {
+	_, err := fmt.Fprintln(rw.w, string(record.Body))
+	return err
+}
+
+func (rw *rawWriter) close() error {
+	return nil
+}
+
+// Writes indented JSON, or one compact object per line in NDJSON mode
+type jsonWriter struct {
+	w      io.Writer
+	ndjson bool
+	indent string // indentation per level, or empty for compact output
+	color  bool   // highlight indented output for a terminal
+}
+
+func (jw *jsonWriter) write(value string, record *ip2whois.Record, jsonData map[string]interface{}) error {
+	var (
+		formatted []byte
+		err       error
+	)
+	if jw.ndjson {
+		// Record the queried value so streamed results can be correlated
+		jsonData["query"] = value
+		formatted, err = json.Marshal(jsonData)
+	} else {
+		formatted, err = marshalJSON(jsonData, jw.indent)
+	}
+	if err != nil {
+		return fmt.Errorf("Error formatting JSON: %v", err)
+	}
+
+	if jw.color && !jw.ndjson {
+		_, err = fmt.Fprintln(jw.w, colorizeJSON(formatted))
+		return err
+	}
+	_, err = fmt.Fprintln(jw.w, string(formatted))
+	return err
+}
+
+// In NDJSON mode failures become objects in the stream so one pass records everything
+func (jw *jsonWriter) writeError(value string, lookupErr error) error {
+	if !jw.ndjson {
+		return nil
+	}
+
+	formatted, err := json.Marshal(errorObject(value, lookupErr))
+	if err != nil {
+		return fmt.Errorf("Error formatting JSON: %v", err)
+	}
+
+	_, err = fmt.Fprintln(jw.w, string(formatted))
+	return err
+}
+
+func (jw *jsonWriter) close() error {
+	return nil
+}
+
+// Re-decode data through JSON so numbers and nested values have the types a
+// parsed document would, as added fields like days_to_expiry are ints
+func roundTripJSON(jsonData map[string]interface{}) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(jsonData)
+	if err != nil {
+		return nil, fmt.Errorf("Error formatting JSON: %v", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// Marshal with the given indentation, or compactly if it is empty
+func marshalJSON(v interface{}, indent string) ([]byte, error) {
+	if indent == "" {
+		return json.Marshal(v)
+	}
+	return json.MarshalIndent(v, "", indent)
+}
+
+// Collects every result, including failures, into one JSON array written on close
+type jsonArrayWriter struct {
+	w       io.Writer
+	indent  string
+	results []interface{}
+}
+
+func (aw *jsonArrayWriter) write(value string, record *ip2whois.Record, jsonData map[string]interface{}) error {
+	jsonData["query"] = value
+	aw.results = append(aw.results, jsonData)
+	return nil
+}
+
+func (aw *jsonArrayWriter) writeError(value string, err error) error {
+	aw.results = append(aw.results, errorObject(value, err))
+	return nil
+}
+
+func (aw *jsonArrayWriter) close() error {
+	// An empty run is still a valid document
+	if aw.results == nil {
+		aw.results = []interface{}{}
+	}
+
+	formatted, err := marshalJSON(aw.results, aw.indent)
+	if err != nil {
+		return fmt.Errorf("Error formatting JSON: %v", err)
+	}
+
+	_, err = fmt.Fprintln(aw.w, string(formatted))
+	return err
+}
+
+// Describe a failed lookup as a JSON object
+func errorObject(value string, err error) map[string]interface{} {
+	return map[string]interface{}{
+		"domain": value,
+		"query":  value,
+		"error":  err.Error(),
+	}
+}
+
+// An output column and the dotted path it is read from
+type column struct {
+	name string
+	path string
+}
+
+// Columns written by -csv
+var csvColumns = []column{
+	{"domain", "domain"},
+	{"registrar", "registrar.name"},
+	{"create_date", "create_date"},
+	{"expire_date", "expire_date"},
+	{"status", "status"},
+	{"nameservers", "nameservers"},
+}
+
+// Writes a fixed set of columns with a single header row, or with flat
+// data, a column for every key of the first record
+type csvWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+	flat        bool
+	columns     []column
+	seen        map[string]bool // keys in the header or already warned about
+}
+
+func newCSVWriter(w io.Writer, flat bool) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w), flat: flat, columns: csvColumns, seen: make(map[string]bool)}
+}
+
+func (cw *csvWriter) write(value string, record *ip2whois.Record, jsonData map[string]interface{}) error {
+	if !cw.wroteHeader {
+		if cw.flat {
+			cw.columns = []column{{"domain", "domain"}}
+			for _, key := range sortedKeys(jsonData) {
+				if key != "domain" {
+					cw.columns = append(cw.columns, column{key, key})
+				}
+			}
+		}
+		header := make([]string, len(cw.columns))
+		for i, column := range cw.columns {
+			header[i] = column.name
+			cw.seen[column.path] = true
+		}
+		cw.w.Write(header)
+		cw.wroteHeader = true
+	}
+
+	// The header is already written, so keys it lacks can't be added
+	if cw.flat {
+		for _, key := range sortedKeys(jsonData) {
+			if !cw.seen[key] {
+				cw.seen[key] = true
+				logf("%s: warning: field %s is not in the CSV header and was left out", value, key)
+			}
+		}
+	}
+
+	row := make([]string, len(cw.columns))
+	for i, column := range cw.columns {
+		row[i] = cellValue(jsonData, column.path)
+	}
+	if row[0] == "" {
+		row[0] = value
+	}
+	cw.w.Write(row)
+
+	// Flush every row so results stream as they complete
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (cw *csvWriter) close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// Columns written by -table
+var tableColumns = []column{
+	{"DOMAIN", "domain"},
+	{"REGISTRAR", "registrar.name"},
+	{"CREATED", "create_date"},
+	{"EXPIRES", "expire_date"},
+	{"STATUS", "status"},
+	{"NAMESERVERS", "nameservers"},
+}
+
+// Nameservers shown per row before the list is truncated
+const tableMaxNameservers = 2
+
+// Writes an aligned text table, buffered until close so columns line up
+type tableWriter struct {
+	w           *tabwriter.Writer
+	wroteHeader bool
+	color       bool // bold the header and highlight expiring and alarming rows
+}
+
+func newTableWriter(w io.Writer, color bool) *tableWriter {
+	return &tableWriter{w: tabwriter.NewWriter(w, 0, 0, 2, ' ', 0), color: color}
+}
+
+func (tw *tableWriter) write(value string, record *ip2whois.Record, jsonData map[string]interface{}) error {
+	if !tw.wroteHeader {
+		header := make([]string, len(tableColumns))
+		for i, column := range tableColumns {
+			header[i] = column.name
+			if tw.color {
+				header[i] = paint(colorBold, header[i])
+			}
+		}
+		fmt.Fprintln(tw.w, strings.Join(header, "\t"))
+		tw.wroteHeader = true
+	}
+
+	row := make([]string, len(tableColumns))
+	for i, column := range tableColumns {
+		row[i] = tableCell(jsonData, column.path)
+	}
+	if row[0] == "" {
+		row[0] = value
+	}
+
+	// Every cell is painted, even in the default color, so each carries the
+	// same invisible bytes and the columns still line up
+	if tw.color {
+		for i, column := range tableColumns {
+			code := colorDefault
+			switch {
+			case column.path == "expire_date" && expiresSoon(record.ExpireDate):
+				code = colorRed
+			case column.path == "status" && statusAlarming(row[i]):
+				code = colorYellow
+			}
+			row[i] = paint(code, row[i])
+		}
+	}
+
+	_, err := fmt.Fprintln(tw.w, strings.Join(row, "\t"))
+	return err
+}
+
+func (tw *tableWriter) close() error {
+	return tw.w.Flush()
+}
+
+// Render a table cell, shortening dates and long nameserver lists
+func tableCell(data map[string]interface{}, path string) string {
+	value, _ := lookupPath(data, strings.Split(path, "."))
+	switch v := value.(type) {
+	case string:
+		if strings.HasSuffix(path, "_date") {
+			if t, err := ip2whois.ParseDate(v); err == nil {
+				return t.Format("2006-01-02")
+			}
+		}
+		// Status codes often carry ICANN reference URLs; keep just the codes
+		var words []string
+		for _, word := range strings.Fields(v) {
+			if path != "status" || !strings.HasPrefix(word, "http") {
+				words = append(words, word)
+			}
+		}
+		return strings.Join(words, " ")
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			if len(items) == tableMaxNameservers {
+				items = append(items, "…")
+				break
+			}
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, ", ")
+	case nil:
+		return "-"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Render the value at a dotted path as a single cell, joining arrays with ";"
+func cellValue(data map[string]interface{}, path string) string {
+	value, ok := lookupPath(data, strings.Split(path, "."))
+	if !ok || value == nil {
+		return ""
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ";")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Project the data down to the given dotted paths, omitting unknown ones or,
+// with keepMissing, setting them to null
+func selectFields(data map[string]interface{}, paths []string, keepMissing bool) map[string]interface{} {
+	selected := make(map[string]interface{})
+	for _, path := range paths {
+		parts := strings.Split(path, ".")
+		if value, ok := lookupPath(data, parts); ok || keepMissing {
+			setPath(selected, parts, value)
+		}
+	}
+
+	return selected
+}
+
+// Remove the given dotted paths, copying the objects along each path rather
+// than modifying them, as they may be shared with the record's Raw map
+func excludeFields(data map[string]interface{}, paths []string) map[string]interface{} {
+	data = copyObject(data)
+	for _, path := range paths {
+		parts := strings.Split(path, ".")
+		object := data
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := object[part].(map[string]interface{})
+			if !ok {
+				object = nil
+				break
+			}
+			child = copyObject(child)
+			object[part] = child
+			object = child
+		}
+		if object != nil {
+			delete(object, parts[len(parts)-1])
+		}
+	}
+	return data
+}
+
+// Make a shallow copy of an object
+func copyObject(data map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		copied[key] = value
+	}
+	return copied
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Walk nested objects following the path segments, or take the dotted key
+// itself from flattened data
+func lookupPath(data map[string]interface{}, parts []string) (interface{}, bool) {
+	if value, ok := data[strings.Join(parts, ".")]; ok && len(parts) > 1 {
+		return value, true
+	}
+
+	var current interface{} = data
+	for _, part := range parts {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[part]; !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// Store a value at the path, creating intermediate objects as needed
+func setPath(data map[string]interface{}, parts []string, value interface{}) {
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := data[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			data[part] = next
+		}
+		data = next
+	}
+	data[parts[len(parts)-1]] = value
+}
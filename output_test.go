@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/xhzeem/ip2whois/pkg/ip2whois"
+)
+
+func normalizeNSRecord() *ip2whois.Record {
+	return &ip2whois.Record{
+		Domain: "example.com",
+		Raw: map[string]interface{}{
+			"domain":      "example.com",
+			"nameservers": []interface{}{"NS2.example.com", "ns1.example.com", "ns1.example.com"},
+		},
+		Nameservers: []string{"ns1.example.com", "ns2.example.com"},
+	}
+}
+
+func TestNormalizeNSCSV(t *testing.T) {
+	jsonData := prepareRecord("example.com", normalizeNSRecord(), outputOptions{normalizeNS: true})
+
+	var out bytes.Buffer
+	if err := newCSVWriter(&out, false).write("example.com", normalizeNSRecord(), jsonData); err != nil {
+		t.Fatal(err)
+	}
+	want := "domain,registrar,create_date,expire_date,status,nameservers\n" +
+		"example.com,,,,,ns1.example.com;ns2.example.com\n"
+	if out.String() != want {
+		t.Errorf("CSV = %q, want %q", out.String(), want)
+	}
+}
+
+func TestNormalizeNSFlatten(t *testing.T) {
+	tests := []struct {
+		name string
+		opts outputOptions
+		want map[string]interface{}
+	}{
+		{
+			name: "indexed",
+			opts: outputOptions{normalizeNS: true, flatten: true},
+			want: map[string]interface{}{
+				"domain":        "example.com",
+				"nameservers.0": "ns1.example.com",
+				"nameservers.1": "ns2.example.com",
+			},
+		},
+		{
+			name: "joined",
+			opts: outputOptions{normalizeNS: true, flatten: true, joinArrays: true},
+			want: map[string]interface{}{
+				"domain":      "example.com",
+				"nameservers": "ns1.example.com;ns2.example.com",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := prepareRecord("example.com", normalizeNSRecord(), tt.opts)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("prepareRecord() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package ip2whois
+
+import (
+	"net/url"
+	"time"
+)
+
+// Decisions taken after an attempt
+const (
+	DecisionSucceed = "succeed" // the response was used
+	DecisionRetry   = "retry"   // the same key will be tried again after a backoff
+	DecisionRotate  = "rotate"  // the next key will be tried
+	DecisionFail    = "fail"    // no keys are left, so the lookup fails
+)
+
+// Attempt describes a single HTTP request made during a lookup.
+type Attempt struct {
+	Value    string        // the queried domain or IP
+	KeyIndex int           // position in Client.Keys of the key used
+	Key      string        // the key used, masked
+	URL      string        // the request URL with the key redacted
+	Status   int           // the HTTP status code, or 0 if no response arrived
+	Latency  time.Duration // time from sending the request to reading the body
+	Err      error         // why the attempt failed, if it did
+	Decision string        // one of the Decision constants
+}
+
+// LookupError is returned when a lookup that made requests fails. It holds
+// every attempt, in order, so the failure can be explained key by key; its
+// message and errors.Is behavior are those of Err.
+type LookupError struct {
+	Attempts []Attempt
+	Err      error
+}
+
+func (e *LookupError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LookupError) Unwrap() error {
+	return e.Err
+}
+
+// MaskKey hides all but the first and last four characters of an API key,
+// as in "abcd****wxyz", so it can appear in logs and errors. Keys too short
+// to reveal anything safely are masked entirely.
+func MaskKey(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[:4] + "****" + key[len(key)-4:]
+}
+
+// Replace the key parameter in a request URL with ***
+func redactURL(u *url.URL) string {
+	redacted := *u
+	params := redacted.Query()
+	params.Del("key")
+	redacted.RawQuery = "key=***"
+	if encoded := params.Encode(); encoded != "" {
+		redacted.RawQuery += "&" + encoded
+	}
+	return redacted.String()
+}
+
+func (c *Client) reportAttempt(attempt Attempt) {
+	if c.OnAttempt != nil {
+		c.OnAttempt(attempt)
+	}
+}
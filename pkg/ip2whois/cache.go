@@ -0,0 +1,80 @@
+package ip2whois
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Cache is an on-disk store of successful responses, one file per queried
+// value, reused until they are older than the TTL.
+type Cache struct {
+	dir string
+	ttl time.Duration
+}
+
+// Cached response along with when it was fetched
+type cacheEntry struct {
+	FetchedAt time.Time       `json:"fetched_at"`
+	Response  json.RawMessage `json:"response"`
+}
+
+// NewCache returns a cache stored in dir, creating the directory if needed.
+func NewCache(dir string, ttl time.Duration) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dir, ttl: ttl}, nil
+}
+
+// Escape the value so it can't traverse outside the cache directory
+func (c *Cache) path(value string) string {
+	return filepath.Join(c.dir, url.PathEscape(value)+".json")
+}
+
+// Return the cached response if it exists and is still fresh
+func (c *Cache) get(value string) ([]byte, bool) {
+	data, err := ioutil.ReadFile(c.path(value))
+	if err != nil {
+		return nil, false
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+
+	if time.Since(entry.FetchedAt) > c.ttl {
+		return nil, false
+	}
+	return entry.Response, true
+}
+
+// Store a response, writing through a temporary file so readers never see partial entries
+func (c *Cache) put(value string, body []byte) error {
+	data, err := json.Marshal(cacheEntry{
+		FetchedAt: time.Now(),
+		Response:  json.RawMessage(body),
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(c.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(value))
+}
@@ -0,0 +1,89 @@
+package ip2whois
+
+import "strings"
+
+// DefaultCleanWords are placeholders registrars put in place of withheld
+// contact data.
+var DefaultCleanWords = []string{
+	"REDACTED",
+	"Data Protected",
+	"Privacy service",
+	"GDPR Masked",
+	"Not Disclosed",
+	"Withheld for Privacy",
+	"Non-Public Data",
+}
+
+// CleanOptions selects which fields CleanFields removes.
+type CleanOptions struct {
+	Words        []string // placeholders marking a value as redacted, matched ignoring case
+	DropRedacted bool     // drop strings containing one of Words
+	DropEmpty    bool     // drop empty strings, and objects and arrays left empty
+	DropNull     bool     // drop null values
+}
+
+// RemoveRedactedAndEmptyFields recursively filters out empty fields and
+// strings containing any of the words, ignoring case.
+func RemoveRedactedAndEmptyFields(data map[string]interface{}, words []string) map[string]interface{} {
+	return CleanFields(data, CleanOptions{Words: words, DropRedacted: true, DropEmpty: true, DropNull: true})
+}
+
+// CleanFields recursively filters out the kinds of field selected by opts.
+func CleanFields(data map[string]interface{}, opts CleanOptions) map[string]interface{} {
+	lowered := make([]string, len(opts.Words))
+	for i, word := range opts.Words {
+		lowered[i] = strings.ToLower(word)
+	}
+	opts.Words = lowered
+	return removeMatchingFields(data, opts)
+}
+
+func removeMatchingFields(data map[string]interface{}, opts CleanOptions) map[string]interface{} {
+	cleaned := make(map[string]interface{})
+
+	for key, value := range data {
+		if v, ok := cleanValue(value, opts); ok {
+			cleaned[key] = v
+		}
+	}
+
+	return cleaned
+}
+
+// Clean a single value, reporting false if it should be dropped
+func cleanValue(value interface{}, opts CleanOptions) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		// If the value is a string, check if it contains a placeholder or if it's empty
+		if v == "" {
+			return v, !opts.DropEmpty
+		}
+		return v, !opts.DropRedacted || !containsAny(strings.ToLower(v), opts.Words)
+	case map[string]interface{}:
+		// Recursively clean nested objects
+		cleanedNested := removeMatchingFields(v, opts)
+		return cleanedNested, len(cleanedNested) > 0 || !opts.DropEmpty
+	case []interface{}:
+		// Clean each element, dropping the array if nothing is left
+		cleanedItems := []interface{}{}
+		for _, item := range v {
+			if cleanedItem, ok := cleanValue(item, opts); ok {
+				cleanedItems = append(cleanedItems, cleanedItem)
+			}
+		}
+		return cleanedItems, len(cleanedItems) > 0 || !opts.DropEmpty
+	default:
+		// Keep other data types (numbers, booleans, etc.) but remove `null` values
+		return v, v != nil || !opts.DropNull
+	}
+}
+
+// Report whether the lowercased value contains any of the lowercased words
+func containsAny(value string, words []string) bool {
+	for _, word := range words {
+		if strings.Contains(value, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package ip2whois
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCleanArrays(t *testing.T) {
+	all := CleanOptions{Words: DefaultCleanWords, DropRedacted: true, DropEmpty: true, DropNull: true}
+
+	tests := []struct {
+		name string
+		opts CleanOptions
+		in   map[string]interface{}
+		want map[string]interface{}
+	}{
+		{
+			name: "string array",
+			opts: all,
+			in: map[string]interface{}{
+				"nameservers": []interface{}{"ns1.example.com", "", "REDACTED FOR PRIVACY", "ns2.example.com"},
+			},
+			want: map[string]interface{}{
+				"nameservers": []interface{}{"ns1.example.com", "ns2.example.com"},
+			},
+		},
+		{
+			name: "string array matched ignoring case",
+			opts: all,
+			in: map[string]interface{}{
+				"emails": []interface{}{"data protected", "admin@example.com"},
+			},
+			want: map[string]interface{}{
+				"emails": []interface{}{"admin@example.com"},
+			},
+		},
+		{
+			name: "array of objects with redacted values",
+			opts: all,
+			in: map[string]interface{}{
+				"contacts": []interface{}{
+					map[string]interface{}{"name": "REDACTED", "email": "tech@example.com", "fax": ""},
+					map[string]interface{}{"name": "Jane Doe", "phone": nil},
+				},
+			},
+			want: map[string]interface{}{
+				"contacts": []interface{}{
+					map[string]interface{}{"email": "tech@example.com"},
+					map[string]interface{}{"name": "Jane Doe"},
+				},
+			},
+		},
+		{
+			name: "objects left empty are dropped from the array",
+			opts: all,
+			in: map[string]interface{}{
+				"contacts": []interface{}{
+					map[string]interface{}{"name": "REDACTED", "email": "Non-Public Data"},
+					map[string]interface{}{"name": "Jane Doe"},
+				},
+			},
+			want: map[string]interface{}{
+				"contacts": []interface{}{
+					map[string]interface{}{"name": "Jane Doe"},
+				},
+			},
+		},
+		{
+			name: "array left empty is dropped",
+			opts: all,
+			in: map[string]interface{}{
+				"domain":      "example.com",
+				"nameservers": []interface{}{"", "REDACTED"},
+				"contacts":    []interface{}{map[string]interface{}{"name": "GDPR Masked"}},
+				"none":        []interface{}{},
+			},
+			want: map[string]interface{}{
+				"domain": "example.com",
+			},
+		},
+		{
+			name: "array left empty is kept without DropEmpty",
+			opts: CleanOptions{Words: DefaultCleanWords, DropRedacted: true},
+			in: map[string]interface{}{
+				"nameservers": []interface{}{"REDACTED"},
+			},
+			want: map[string]interface{}{
+				"nameservers": []interface{}{},
+			},
+		},
+		{
+			name: "nested arrays",
+			opts: all,
+			in: map[string]interface{}{
+				"groups": []interface{}{
+					[]interface{}{"a", "REDACTED"},
+					[]interface{}{"", nil},
+				},
+			},
+			want: map[string]interface{}{
+				"groups": []interface{}{
+					[]interface{}{"a"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CleanFields(tt.in, tt.opts)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CleanFields() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
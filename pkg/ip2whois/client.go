@@ -0,0 +1,656 @@
+// Package ip2whois is a client for the IP2Whois domain WHOIS API and the
+// IP2Location.io IP lookup API, rotating through several API keys.
+package ip2whois
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Query types accepted by the API endpoints
+const (
+	QueryDomain = "domain"
+	QueryIP     = "ip"
+)
+
+// Default API endpoints for domain and IP queries
+const (
+	DefaultBaseURL   = "https://api.ip2whois.com/v2"
+	DefaultIPBaseURL = "https://api.ip2location.io/"
+)
+
+// ErrTimeout is returned when a request exceeds the HTTP client timeout.
+var ErrTimeout = errors.New("request timed out")
+
+// ErrResponseTooLarge is returned when a response body exceeds
+// Client.MaxResponseSize.
+var ErrResponseTooLarge = errors.New("response too large")
+
+// ErrRedirectRefused is returned when the API redirects a request somewhere
+// CheckSameHostRedirect won't follow.
+var ErrRedirectRefused = errors.New("redirect refused")
+
+// CheckSameHostRedirect is an http.Client CheckRedirect policy that only
+// follows redirects to the same host without a downgrade from https, so API
+// keys in the request never reach another server. NewClient installs it.
+func CheckSameHostRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	original := via[0].URL
+	if !strings.EqualFold(req.URL.Host, original.Host) {
+		return fmt.Errorf("%w: %s redirected to another host, %s", ErrRedirectRefused, original.Host, req.URL.Host)
+	}
+	if original.Scheme == "https" && req.URL.Scheme != "https" {
+		return fmt.Errorf("%w: %s redirected from https to %s", ErrRedirectRefused, original.Host, req.URL.Scheme)
+	}
+	return nil
+}
+
+// DefaultMaxResponseSize is the body size limit set by NewClient.
+const DefaultMaxResponseSize = 4 << 20
+
+// StatusError is returned when the API responds with a non-200 status code.
+type StatusError struct {
+	Code       int
+	RetryAfter time.Duration // parsed from the Retry-After header, if present
+}
+
+func (e *StatusError) Error() string {
+	if e.Code == http.StatusTooManyRequests {
+		return fmt.Sprintf("Error: Rate limited by the API (status code %d)", e.Code)
+	}
+	return fmt.Sprintf("Error: Received status code %d", e.Code)
+}
+
+// Transient reports whether the status usually clears up on its own, as with
+// rate limiting and server errors.
+func (e *StatusError) Transient() bool {
+	switch e.Code {
+	case 429, 500, 502, 503, 504:
+		return true
+	}
+	return false
+}
+
+// Client looks up WHOIS records, trying each API key in turn until one works.
+// Errors that don't depend on the key, such as ErrDomainNotFound and
+// ErrInvalidResponse, are returned without trying the remaining keys. It is
+// safe for concurrent use.
+type Client struct {
+	Keys       []string
+	HTTPClient *http.Client
+
+	// BaseURL and IPBaseURL are the endpoints for domain and IP queries,
+	// which can point at a test server or caching proxy.
+	BaseURL   string
+	IPBaseURL string
+
+	// UserAgent, if set, replaces Go's default User-Agent header.
+	UserAgent string
+
+	// Header holds extra headers sent with every request, such as tokens
+	// for an API gateway. They override UserAgent.
+	Header http.Header
+
+	// DiscardBody leaves Record.Body nil, sparing a copy of every response
+	// when only the decoded fields are used. Bodies are still kept for the
+	// cache.
+	DiscardBody bool
+
+	// MaxResponseSize caps how many bytes of a response body are read, or
+	// is unlimited if zero.
+	MaxResponseSize int64
+
+	// Retries is the number of extra attempts per key on transient errors.
+	Retries int
+
+	// Cache, if set, is consulted before and updated after each request.
+	Cache *Cache
+
+	// Limiter, if set, caps the rate of requests, retries included.
+	Limiter *RateLimiter
+
+	// Logf, if set, receives notices about key rotation and cache hits.
+	Logf func(format string, args ...interface{})
+
+	// Logger, if set, receives the same notices as structured records in
+	// place of Logf, with the domain, masked key, status and latency as
+	// attributes where they apply.
+	Logger *slog.Logger
+
+	// OnAttempt, if set, is called after every HTTP request.
+	OnAttempt func(Attempt)
+
+	// PerKeyConcurrency, if positive, caps the requests in flight with each
+	// key. Lookups then start at the first key with a free slot, or wait for
+	// any key to free one, so work spreads across the keys instead of
+	// queueing behind the first.
+	PerKeyConcurrency int
+
+	// RoundRobin starts each lookup at the key after the one the previous
+	// lookup started at, instead of always starting at the first key.
+	RoundRobin bool
+	next       uint64
+
+	// KeyRounds is the number of times the whole key set is tried again,
+	// after waiting KeyCooldown, when every key failed and at least one did
+	// so with an exhausted quota or a transient error. Invalid domains and
+	// other errors that every key would see still fail at once.
+	KeyRounds   int
+	KeyCooldown time.Duration
+
+	mu      sync.Mutex
+	credits map[int]interface{} // last known balance per key index
+	slots   []chan struct{}     // per-key semaphores for PerKeyConcurrency
+}
+
+// NewClient returns a client for the given API keys with a 30 second timeout,
+// two retries per key, a DefaultMaxResponseSize body limit, and redirects
+// limited by CheckSameHostRedirect.
+func NewClient(keys ...string) *Client {
+	return &Client{
+		Keys:       keys,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second, CheckRedirect: CheckSameHostRedirect},
+		BaseURL:    DefaultBaseURL,
+		IPBaseURL:  DefaultIPBaseURL,
+		Retries:    2,
+
+		MaxResponseSize: DefaultMaxResponseSize,
+	}
+}
+
+// Lookup fetches the WHOIS record for a domain. The input is normalized with
+// NormalizeDomain first, so invalid names fail without making a request.
+func (c *Client) Lookup(ctx context.Context, domain string) (*Record, error) {
+	normalized, err := NormalizeDomain(domain)
+	if err != nil {
+		return nil, err
+	}
+	return c.query(ctx, QueryDomain, normalized)
+}
+
+// Name returns "ip2whois", identifying the client as a Provider.
+func (c *Client) Name() string {
+	return "ip2whois"
+}
+
+// LookupIP fetches the IP2Location.io record for an IPv4 or IPv6 address.
+// Only Raw and Body are populated.
+func (c *Client) LookupIP(ctx context.Context, ip string) (*Record, error) {
+	return c.query(ctx, QueryIP, ip)
+}
+
+// RequestURL returns the URL a query for value would request, with the API
+// key redacted. It makes no network calls.
+func (c *Client) RequestURL(queryType, value string) (string, error) {
+	reqURL, err := c.requestURL("", queryType, value)
+	if err != nil {
+		return "", err
+	}
+	return redactURL(reqURL), nil
+}
+
+func (c *Client) logf(format string, args ...interface{}) {
+	if c.Logf != nil {
+		c.Logf(format, args...)
+	}
+}
+
+// Send a notice to Logger as msg with attrs, or to Logf as text
+func (c *Client) notice(ctx context.Context, level slog.Level, msg string, attrs []interface{}, text string) {
+	if c.Logger != nil {
+		c.Logger.Log(ctx, level, msg, attrs...)
+		return
+	}
+	c.logf("%s", text)
+}
+
+// Attributes describing how the last attempt went
+func attemptAttrs(attempts []Attempt) []interface{} {
+	if len(attempts) == 0 {
+		return nil
+	}
+	last := attempts[len(attempts)-1]
+	return []interface{}{
+		"status", last.Status,
+		"latency_ms", last.Latency.Milliseconds(),
+	}
+}
+
+// Query with each API key until one works, consulting the cache first
+func (c *Client) query(ctx context.Context, queryType, value string) (*Record, error) {
+	if c.Cache != nil {
+		if body, ok := c.Cache.get(value); ok {
+			if record, err := decodeRecord(body); err == nil {
+				c.notice(ctx, slog.LevelInfo, "cache hit", []interface{}{"domain", value},
+					fmt.Sprintf("%s: cache hit", value))
+				record.Cached = true
+				record.Provider = c.Name()
+				return record, nil
+			}
+		}
+	}
+
+	// Round-robin starts each lookup at the next key to spread quota usage
+	start := 0
+	if c.RoundRobin && len(c.Keys) > 0 {
+		start = int((atomic.AddUint64(&c.next, 1) - 1) % uint64(len(c.Keys)))
+	}
+	held := false
+	if c.PerKeyConcurrency > 0 && len(c.Keys) > 0 {
+		i, err := c.idleKey(ctx, start)
+		if err != nil {
+			return nil, err
+		}
+		start, held = i, true
+	}
+
+	var attempts []Attempt
+	for round := 0; ; round++ {
+		record, recoverable, err := c.tryKeys(ctx, start, held && round == 0, queryType, value, &attempts)
+		if err == nil {
+			return record, nil
+		}
+		if !recoverable || round >= c.KeyRounds {
+			if len(attempts) > 0 && ctx.Err() == nil {
+				err = &LookupError{Attempts: attempts, Err: err}
+			}
+			return nil, err
+		}
+
+		attrs := append([]interface{}{
+			"domain", value,
+			"error", err.Error(),
+			"cooldown_ms", c.KeyCooldown.Milliseconds(),
+			"round", round + 2,
+			"rounds", c.KeyRounds + 1,
+		}, attemptAttrs(attempts)...)
+		c.notice(ctx, slog.LevelWarn, "all keys failed, retrying", attrs,
+			fmt.Sprintf("%s: %v, retrying all keys in %s (round %d of %d)", value, err, c.KeyCooldown, round+2, c.KeyRounds+1))
+		select {
+		case <-time.After(c.KeyCooldown):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Query with each API key in turn starting at start, until one works. When
+// all fail, recoverable reports whether any failed for a reason that may
+// clear up with time, such as an exhausted quota. Every request made is
+// appended to attempts.
+func (c *Client) tryKeys(ctx context.Context, start int, held bool, queryType, value string, attempts *[]Attempt) (*Record, bool, error) {
+	var lastErr error
+	recoverable := false
+	for n := range c.Keys {
+		i := (start + n) % len(c.Keys)
+		if n > 0 {
+			prev := (i + len(c.Keys) - 1) % len(c.Keys)
+			attrs := append([]interface{}{
+				"domain", value,
+				"key_index", prev + 1,
+				"key", MaskKey(c.Keys[prev]),
+				"error", lastErr.Error(),
+				"next_key_index", i + 1,
+				"next_key", MaskKey(c.Keys[i]),
+			}, attemptAttrs(*attempts)...)
+			c.notice(ctx, slog.LevelInfo, "switching key", attrs,
+				fmt.Sprintf("%s: key #%d (%s) failed (%v), switching to key #%d (%s)%s",
+					value, prev+1, MaskKey(c.Keys[prev]), lastErr, i+1, MaskKey(c.Keys[i]), c.creditsNote(i)))
+		}
+
+		record, err := c.fetch(ctx, i, n == len(c.Keys)-1, held && n == 0, queryType, value, attempts)
+		if err != nil {
+			// A cancelled lookup would fail the same way with every key
+			if ctx.Err() != nil {
+				return nil, false, ctx.Err()
+			}
+			// Only quota, key and transient errors are worth another key
+			if sameForEveryKey(err) {
+				return nil, false, err
+			}
+			if errors.Is(err, ErrInvalidKey) {
+				attrs := append([]interface{}{
+					"domain", value,
+					"key_index", i + 1,
+					"key", MaskKey(c.Keys[i]),
+					"error", err.Error(),
+				}, attemptAttrs(*attempts)...)
+				c.notice(ctx, slog.LevelWarn, "key rejected", attrs,
+					fmt.Sprintf("warning: key #%d (%s) was rejected by the API: %v", i+1, MaskKey(c.Keys[i]), err))
+			}
+			if recoverableError(err) {
+				recoverable = true
+			}
+			lastErr = err
+			continue
+		}
+
+		record.KeyIndex = i
+		record.Provider = c.Name()
+
+		if credits, ok := RemainingCredits(record.Raw); ok {
+			c.setCredits(i, credits)
+		}
+
+		if c.Cache != nil {
+			if err := c.Cache.put(value, record.Body); err != nil {
+				c.notice(ctx, slog.LevelWarn, "cannot write cache entry", []interface{}{"domain", value, "error", err.Error()},
+					fmt.Sprintf("%s: warning: cannot write cache entry: %v", value, err))
+			}
+		}
+
+		return record, false, nil
+	}
+
+	return nil, recoverable, fmt.Errorf("All API keys failed: %w", lastErr)
+}
+
+// Fetch with a single key, retrying transient errors with exponential
+// backoff; held means the key's slot is already taken for the first attempt.
+// Attempts are appended to attempts unless it is nil.
+func (c *Client) fetch(ctx context.Context, keyIndex int, lastKey, held bool, queryType, value string, attempts *[]Attempt) (*Record, error) {
+	apiKey := c.Keys[keyIndex]
+	reqURL, err := c.requestURL(apiKey, queryType, value)
+	if err != nil {
+		if held {
+			<-c.keySlot(keyIndex)
+		}
+		return nil, err
+	}
+
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		// Hold a slot for the key only while the request is in flight, not
+		// during backoff
+		var slot chan struct{}
+		if c.PerKeyConcurrency > 0 {
+			slot = c.keySlot(keyIndex)
+			if !held || attempt > 0 {
+				select {
+				case slot <- struct{}{}:
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+		}
+		if c.Limiter != nil {
+			if err := c.Limiter.Wait(ctx); err != nil {
+				if slot != nil {
+					<-slot
+				}
+				return nil, err
+			}
+		}
+
+		start := time.Now()
+		record, status, err := c.fetchIP2Whois(ctx, reqURL)
+		if slot != nil {
+			<-slot
+		}
+
+		var statusErr *StatusError
+		isStatus := errors.As(err, &statusErr)
+		retry := err != nil && attempt < c.Retries && isStatus && statusErr.Transient()
+
+		decision := DecisionSucceed
+		switch {
+		case retry:
+			decision = DecisionRetry
+		case err != nil && !lastKey && !sameForEveryKey(err):
+			decision = DecisionRotate
+		case err != nil:
+			decision = DecisionFail
+		}
+		report := Attempt{
+			Value:    value,
+			KeyIndex: keyIndex,
+			Key:      MaskKey(apiKey),
+			URL:      redactURL(reqURL),
+			Status:   status,
+			Latency:  time.Since(start),
+			Err:      err,
+			Decision: decision,
+		}
+		c.reportAttempt(report)
+		if attempts != nil {
+			*attempts = append(*attempts, report)
+		}
+
+		if !retry {
+			if isStatus && statusErr.Code == http.StatusTooManyRequests && attempt > 0 {
+				err = fmt.Errorf("%w, still rate limited after %d retries", err, attempt)
+			}
+			return record, err
+		}
+
+		// Prefer the server's requested delay over our own backoff
+		wait := delay
+		if statusErr.RetryAfter > 0 {
+			wait = statusErr.RetryAfter
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// Return the semaphore limiting requests in flight with a key
+func (c *Client) keySlot(keyIndex int) chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.slots == nil {
+		c.slots = make([]chan struct{}, len(c.Keys))
+		for i := range c.slots {
+			c.slots[i] = make(chan struct{}, c.PerKeyConcurrency)
+		}
+	}
+	return c.slots[keyIndex]
+}
+
+// Take a slot on the first key from start on that has one free, or wait
+// for whichever key frees one first
+func (c *Client) idleKey(ctx context.Context, start int) (int, error) {
+	cases := make([]reflect.SelectCase, 0, len(c.Keys)+1)
+	for n := range c.Keys {
+		i := (start + n) % len(c.Keys)
+		select {
+		case c.keySlot(i) <- struct{}{}:
+			return i, nil
+		default:
+		}
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectSend,
+			Chan: reflect.ValueOf(c.keySlot(i)),
+			Send: reflect.ValueOf(struct{}{}),
+		})
+	}
+
+	cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+	chosen, _, _ := reflect.Select(cases)
+	if chosen == len(c.Keys) {
+		return 0, ctx.Err()
+	}
+	return (start + chosen) % len(c.Keys), nil
+}
+
+// Build the request URL, encoding the parameters so special characters in the value can't break the query
+func (c *Client) requestURL(apiKey, queryType, value string) (*url.URL, error) {
+	endpoint := c.BaseURL
+	if queryType == QueryIP {
+		endpoint = c.IPBaseURL
+	}
+
+	reqURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	params := reqURL.Query()
+	params.Set("key", apiKey)
+	params.Set(queryType, value)
+	reqURL.RawQuery = params.Encode()
+
+	return reqURL, nil
+}
+
+// Fetch the IP2Whois API, returning the decoded record and status code
+func (c *Client) fetchIP2Whois(ctx context.Context, reqURL *url.URL) (*Record, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	for name, values := range c.Header {
+		req.Header[name] = values
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		// Transport errors embed the request URL, key included
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactURL(reqURL)
+		}
+		return nil, 0, timeoutError(ctx, err, c.HTTPClient.Timeout)
+	}
+	defer resp.Body.Close()
+
+	// Check for non-200 status code
+	if resp.StatusCode != 200 {
+		return nil, resp.StatusCode, &StatusError{
+			Code:       resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	// Decode while reading rather than buffering first, copying the bytes
+	// only when Record.Body or the cache needs them; reading one byte past
+	// the limit detects oversized bodies
+	var reader io.Reader = resp.Body
+	if c.MaxResponseSize > 0 {
+		reader = io.LimitReader(resp.Body, c.MaxResponseSize+1)
+	}
+	body := &bodyReader{r: reader, keep: !c.DiscardBody || c.Cache != nil}
+	decoder := json.NewDecoder(body)
+	var result map[string]interface{}
+	err = decoder.Decode(&result)
+	trailing := false
+	if err == nil {
+		// Read on to the end, so trailing data is caught and Body is complete
+		if _, tokenErr := decoder.Token(); tokenErr != io.EOF {
+			io.Copy(ioutil.Discard, body)
+			if body.err != nil {
+				err = body.err
+			}
+			trailing = true
+		}
+	}
+	if c.MaxResponseSize > 0 && body.n > c.MaxResponseSize {
+		return nil, resp.StatusCode, fmt.Errorf("%w: body exceeds %d bytes", ErrResponseTooLarge, c.MaxResponseSize)
+	}
+	if err != nil && !isJSONError(err) {
+		return nil, resp.StatusCode, timeoutError(ctx, err, c.HTTPClient.Timeout)
+	}
+	switch {
+	case err == io.EOF:
+		err = errors.New("empty body")
+	case trailing:
+		err = errors.New("unexpected data after the JSON object")
+	}
+	if err != nil {
+		return nil, resp.StatusCode, invalidResponse(body.prefix, err)
+	}
+
+	// Check if the response contains an error
+	if apiErr, ok := result["error"]; ok {
+		return nil, resp.StatusCode, parseAPIError(apiErr)
+	}
+
+	return newRecord(body.kept, result), resp.StatusCode, nil
+}
+
+// Counts the bytes read from a response body and keeps the start of it for
+// error messages, and all of it if keep is set
+type bodyReader struct {
+	r      io.Reader
+	keep   bool
+	n      int64
+	prefix []byte
+	kept   []byte
+	err    error // the first read error other than io.EOF
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	if room := invalidResponsePrefix - len(b.prefix); room > 0 {
+		b.prefix = append(b.prefix, p[:min(n, room)]...)
+	}
+	if b.keep {
+		b.kept = append(b.kept, p[:n]...)
+	}
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// Report whether a decoding error is about the body's content, such as a
+// syntax error or truncation, rather than a failure to read it
+func isJSONError(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return errors.As(err, &syntaxErr) || errors.As(err, &typeErr) ||
+		err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+// Wrap timeout errors so callers can tell them apart from other network failures
+func timeoutError(ctx context.Context, err error, timeout time.Duration) error {
+	// Cancellation and context deadlines are the caller's doing, not a slow API
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w after %s", ErrTimeout, timeout)
+	}
+	return err
+}
+
+// Parse a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(header string) time.Duration {
+	header = strings.TrimSpace(header)
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(when); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package ip2whois
+
+import (
+	"context"
+	"fmt"
+)
+
+// Response fields that may carry the remaining query balance
+var creditFields = []string{"credits_remaining", "credits"}
+
+// RemainingCredits extracts the remaining credit balance from a decoded
+// response, if the API reported one.
+func RemainingCredits(data map[string]interface{}) (interface{}, bool) {
+	for _, field := range creditFields {
+		if value, ok := data[field]; ok && value != nil {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// Remember the balance of a key so rotation notices can report it
+func (c *Client) setCredits(index int, credits interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.credits == nil {
+		c.credits = make(map[int]interface{})
+	}
+	c.credits[index] = credits
+}
+
+// Credits returns the last balance the API reported for each key, by
+// position in Keys. Keys that haven't reported one are absent.
+func (c *Client) Credits() map[int]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	credits := make(map[int]interface{}, len(c.credits))
+	for index, balance := range c.credits {
+		credits[index] = balance
+	}
+	return credits
+}
+
+// Describe the last known balance of a key, or an empty string if unknown
+func (c *Client) creditsNote(index int) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if credits, ok := c.credits[index]; ok {
+		return fmt.Sprintf(" (%v credits remaining)", credits)
+	}
+	return ""
+}
+
+// ProbeDomain is the domain CheckKey looks up to test a key.
+const ProbeDomain = "example.com"
+
+// CheckKey looks ProbeDomain up with the key at keyIndex alone, bypassing
+// the cache, and returns the balance the API reported, if any. A nil error
+// means the key works; the probe costs one query of its quota.
+func (c *Client) CheckKey(ctx context.Context, keyIndex int) (credits interface{}, ok bool, err error) {
+	if keyIndex < 0 || keyIndex >= len(c.Keys) {
+		return nil, false, fmt.Errorf("no key #%d", keyIndex+1)
+	}
+	record, err := c.fetch(ctx, keyIndex, true, false, QueryDomain, ProbeDomain, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	credits, ok = RemainingCredits(record.Raw)
+	if ok {
+		c.setCredits(keyIndex, credits)
+	}
+	return credits, ok, nil
+}
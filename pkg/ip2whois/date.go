@@ -0,0 +1,46 @@
+package ip2whois
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Date layouts seen in WHOIS date fields, most common first. Dates without a
+// zone are taken as UTC.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02",
+	"02-Jan-2006",                 // .uk, .io
+	"02-Jan-2006 15:04:05",        // older registrar servers
+	"02-Jan-2006 15:04:05 MST",    // older registrar servers
+	"2006.01.02",                  // .kr
+	"2006.01.02 15:04:05",         // .ru and .su
+	"2006/01/02",                  // .jp
+	"2006/01/02 15:04:05 (MST)",   // .jp
+	"02.01.2006",                  // .cz, .pl, .tr
+	"02.01.2006 15:04:05",         // .cz, .pl, .tr
+	"20060102",                    // .br
+	"Mon Jan 2 15:04:05 MST 2006", // date(1) output from some ccTLDs
+	"January 2 2006",
+	"2 January 2006",
+}
+
+// ParseDate parses a WHOIS date using the layouts registries commonly return,
+// ignoring surrounding whitespace and case. It returns an error naming the
+// value when no layout matches.
+func ParseDate(value string) (time.Time, error) {
+	trimmed := strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, trimmed); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date format %q", value)
+}
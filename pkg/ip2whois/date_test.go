@@ -0,0 +1,61 @@
+package ip2whois
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	day := time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC)
+	moment := time.Date(2024, time.March, 5, 14, 30, 15, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2024-03-05T14:30:15Z", moment},
+		{"2024-03-05T09:30:15-05:00", moment},
+		{"2024-03-05T14:30:15", moment},
+		{"2024-03-05T09:30:15-0500", moment},
+		{"2024-03-05 14:30:15", moment},
+		{"2024-03-05 09:30:15-05:00", moment},
+		{"2024-03-05 14:30:15 UTC", moment},
+		{"2024-03-05 09:30:15 -0500", moment},
+		{"2024-03-05", day},
+		{"05-Mar-2024", day},
+		{"05-Mar-2024 14:30:15", moment},
+		{"05-Mar-2024 14:30:15 UTC", moment},
+		{"2024.03.05", day},
+		{"2024.03.05 14:30:15", moment},
+		{"2024/03/05", day},
+		{"2024/03/05 14:30:15 (UTC)", moment},
+		{"05.03.2024", day},
+		{"05.03.2024 14:30:15", moment},
+		{"20240305", day},
+		{"Tue Mar 5 14:30:15 UTC 2024", moment},
+		{"March 5 2024", day},
+		{"5 March 2024", day},
+		{"05-MAR-2024", day},
+		{"  2024-03-05\n", day},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseDate(tt.value)
+			if err != nil {
+				t.Fatalf("ParseDate(%q) error: %v", tt.value, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseDate(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDateUnrecognized(t *testing.T) {
+	for _, value := range []string{"", "someday", "2024-13-45", "05/03/2024"} {
+		if got, err := ParseDate(value); err == nil {
+			t.Errorf("ParseDate(%q) = %v, want an error", value, got)
+		}
+	}
+}
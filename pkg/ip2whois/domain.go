@@ -0,0 +1,91 @@
+package ip2whois
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NormalizeDomain turns input such as "https://www.Example.com:8080/login?x=1"
+// into a bare, lowercase, ASCII domain and checks that it looks like a
+// registrable name, so malformed input can be rejected without spending an
+// API call. It removes, in order:
+//
+//   - surrounding whitespace and a leading scheme such as "https://"
+//   - any path, query or fragment
+//   - user info before an "@" and a trailing ":port"
+//   - a trailing dot and a leading "www." label
+//
+// Internationalized names are converted to Punycode. Errors wrap
+// ErrInvalidDomain.
+func NormalizeDomain(input string) (string, error) {
+	domain := strings.TrimSpace(input)
+
+	// Drop a leading scheme and anything after the host
+	if i := strings.Index(domain, "://"); i >= 0 {
+		domain = domain[i+3:]
+	}
+	if i := strings.IndexAny(domain, "/?#"); i >= 0 {
+		domain = domain[:i]
+	}
+	if i := strings.LastIndex(domain, "@"); i >= 0 {
+		domain = domain[i+1:]
+	}
+	if i := strings.LastIndex(domain, ":"); i >= 0 {
+		domain = domain[:i]
+	}
+
+	// Convert first, so a trailing ideographic full stop is a dot by the time
+	// it is trimmed
+	domain, err := ToASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %v", ErrInvalidDomain, input, err)
+	}
+	domain = strings.TrimSuffix(domain, ".")
+
+	// Keep "www.com" intact, as stripping would leave only the TLD
+	if rest := strings.TrimPrefix(domain, "www."); rest != domain && strings.Contains(rest, ".") {
+		domain = rest
+	}
+
+	if err := validateDomain(domain); err != nil {
+		return "", fmt.Errorf("%w %q: %v", ErrInvalidDomain, input, err)
+	}
+
+	return domain, nil
+}
+
+// Check the length and characters of each label
+func validateDomain(domain string) error {
+	if domain == "" {
+		return fmt.Errorf("domain is empty")
+	}
+	if len(domain) > 253 {
+		return fmt.Errorf("domain is longer than 253 characters")
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("domain has no top-level domain")
+	}
+
+	for _, label := range labels {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("label %q must be 1 to 63 characters", label)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("label %q cannot start or end with a hyphen", label)
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+				return fmt.Errorf("label %q contains invalid character %q", label, r)
+			}
+		}
+	}
+
+	// Rules out IP addresses, which have an all-numeric final label
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return fmt.Errorf("top-level domain %q is numeric", labels[len(labels)-1])
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package ip2whois
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Classes of error reported by the API in the response body. Use errors.Is
+// to check an error returned by Lookup against them.
+var (
+	ErrQuotaExceeded  = errors.New("API quota exceeded")
+	ErrInvalidKey     = errors.New("invalid API key")
+	ErrInvalidDomain  = errors.New("invalid domain")
+	ErrDomainNotFound = errors.New("domain not found")
+)
+
+// ErrInvalidResponse is returned when a response body isn't the JSON the API
+// sends, as with an HTML page from a captive portal or a truncated body.
+var ErrInvalidResponse = errors.New("invalid response")
+
+// Report whether every key would get the same answer, because the error is
+// about the queried name itself or about what sits between us and the API
+func sameForEveryKey(err error) bool {
+	return errors.Is(err, ErrInvalidDomain) || errors.Is(err, ErrDomainNotFound) ||
+		errors.Is(err, ErrInvalidResponse) || errors.Is(err, ErrResponseTooLarge) ||
+		errors.Is(err, ErrRedirectRefused)
+}
+
+// Report whether a key's failure may clear up if the key is tried again
+// later: its quota ran out, the API was overloaded, or the request timed out
+func recoverableError(err error) bool {
+	var statusErr *StatusError
+	return errors.Is(err, ErrQuotaExceeded) || errors.Is(err, ErrTimeout) ||
+		errors.As(err, &statusErr) && statusErr.Transient()
+}
+
+// How much of a body invalidResponse quotes
+const invalidResponsePrefix = 64
+
+// Wrap a JSON decoding error with the start of the body for debugging
+func invalidResponse(body []byte, err error) error {
+	prefix := body
+	if len(prefix) > invalidResponsePrefix {
+		prefix = prefix[:invalidResponsePrefix]
+	}
+	return fmt.Errorf("%w: %v (body starts with %q)", ErrInvalidResponse, err, prefix)
+}
+
+// APIError is an error object returned in the body of a response.
+type APIError struct {
+	Code    int    // the API's error code, or 0 if it didn't send one
+	Message string // the API's error message
+	Kind    error  // one of the Err sentinels, or nil if unrecognized
+}
+
+func (e *APIError) Error() string {
+	kind := "API error"
+	if e.Kind != nil {
+		kind = e.Kind.Error()
+	}
+	if e.Code != 0 {
+		return fmt.Sprintf("%s: %s (code %d)", kind, e.Message, e.Code)
+	}
+	return fmt.Sprintf("%s: %s", kind, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Kind
+}
+
+// Build an APIError from the "error" field of a response
+func parseAPIError(value interface{}) *APIError {
+	apiErr := &APIError{}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		switch code := v["error_code"].(type) {
+		case float64:
+			apiErr.Code = int(code)
+		case string:
+			apiErr.Code, _ = strconv.Atoi(code)
+		}
+		apiErr.Message, _ = v["error_message"].(string)
+	case string:
+		apiErr.Message = v
+	}
+
+	if apiErr.Message == "" {
+		apiErr.Message = "error in response"
+	}
+	apiErr.Kind = classifyAPIError(apiErr.Message)
+
+	return apiErr
+}
+
+// The API's codes differ between endpoints, but the messages are consistent
+func classifyAPIError(message string) error {
+	m := strings.ToLower(message)
+	switch {
+	case strings.Contains(m, "credit") || strings.Contains(m, "quota") || strings.Contains(m, "limit"):
+		return ErrQuotaExceeded
+	case strings.Contains(m, "key"):
+		return ErrInvalidKey
+	case strings.Contains(m, "not found") || strings.Contains(m, "no whois") || strings.Contains(m, "no record"):
+		return ErrDomainNotFound
+	case strings.Contains(m, "domain") || strings.Contains(m, "ip address"):
+		return ErrInvalidDomain
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package ip2whois
+
+import "testing"
+
+func TestClassifyAPIError(t *testing.T) {
+	tests := []struct {
+		message string
+		want    error
+	}{
+		{"Insufficient credit.", ErrQuotaExceeded},
+		{"Monthly quota exceeded.", ErrQuotaExceeded},
+		{"Rate limit reached.", ErrQuotaExceeded},
+		{"API key not found.", ErrInvalidKey},
+		{"Invalid API key.", ErrInvalidKey},
+		{"Domain not found.", ErrDomainNotFound},
+		{"No WHOIS record found.", ErrDomainNotFound},
+		{"Invalid domain.", ErrInvalidDomain},
+		{"Invalid IP address.", ErrInvalidDomain},
+		{"error in response", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			if got := classifyAPIError(tt.message); got != tt.want {
+				t.Errorf("classifyAPIError(%q) = %v, want %v", tt.message, got, tt.want)
+			}
+		})
+	}
+}
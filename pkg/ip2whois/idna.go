@@ -0,0 +1,12 @@
+package ip2whois
+
+import "golang.org/x/net/idna"
+
+// ToASCII converts an internationalized domain name to the ASCII-compatible
+// (Punycode) form the API expects, so "münchen.de" becomes "xn--mnchen-3ya.de".
+// Names are mapped and checked with the UTS #46 lookup rules, which lowercase
+// them and fold variants such as fullwidth letters and the ideographic full
+// stop.
+func ToASCII(domain string) (string, error) {
+	return idna.Lookup.ToASCII(domain)
+}
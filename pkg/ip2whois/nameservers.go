@@ -0,0 +1,46 @@
+package ip2whois
+
+import (
+	"sort"
+	"strings"
+)
+
+// NormalizeNameservers turns a nameservers field in any of the shapes
+// registrars return, an array or a comma- or space-separated string, into a
+// lowercased, de-duplicated and sorted list. It never returns nil.
+func NormalizeNameservers(value interface{}) []string {
+	var names []string
+	switch v := value.(type) {
+	case string:
+		names = splitNameservers(v)
+	case []string:
+		for _, item := range v {
+			names = append(names, splitNameservers(item)...)
+		}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				names = append(names, splitNameservers(s)...)
+			}
+		}
+	}
+
+	seen := make(map[string]bool, len(names))
+	normalized := []string{}
+	for _, name := range names {
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		if name != "" && !seen[name] {
+			seen[name] = true
+			normalized = append(normalized, name)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// Split a nameserver string on commas and whitespace
+func splitNameservers(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+}
@@ -0,0 +1,179 @@
+package ip2whois
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Provider is a source of domain WHOIS records. Client and RDAP implement it.
+type Provider interface {
+	// Name identifies the provider in logs, such as "ip2whois" or "rdap".
+	Name() string
+
+	// Lookup fetches the record for a domain, normalizing it first.
+	Lookup(ctx context.Context, domain string) (*Record, error)
+}
+
+// Fallback is a Provider that tries each of Providers in turn until one
+// returns a record. Invalid and unregistered domains and cancellation are
+// returned at once, as the next provider would fail the same way; any other
+// error, such as an outage or exhausted keys, moves on to the next one.
+type Fallback struct {
+	Providers []Provider
+
+	// Logf, if set, receives a notice each time a provider is given up on.
+	Logf func(format string, args ...interface{})
+}
+
+// Name returns the provider names joined with commas.
+func (f *Fallback) Name() string {
+	names := make([]string, len(f.Providers))
+	for i, p := range f.Providers {
+		names[i] = p.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+// Lookup queries each provider in order, returning the first record found.
+func (f *Fallback) Lookup(ctx context.Context, domain string) (*Record, error) {
+	if len(f.Providers) == 0 {
+		return nil, errors.New("no providers configured")
+	}
+
+	var lastErr error
+	for i, p := range f.Providers {
+		if i > 0 && f.Logf != nil {
+			f.Logf("%s: provider %s failed (%v), falling back to %s", domain, f.Providers[i-1].Name(), lastErr, p.Name())
+		}
+
+		record, err := p.Lookup(ctx, domain)
+		if err == nil {
+			return record, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, ErrInvalidDomain) || errors.Is(err, ErrDomainNotFound) {
+			return nil, err
+		}
+		lastErr = err
+	}
+
+	if len(f.Providers) == 1 {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("All providers failed: %w", lastErr)
+}
+
+// Supplement is a Provider that turns to Secondary when Primary has no data
+// for a domain: its record is used when Primary reports ErrDomainNotFound,
+// and merged in when Primary's record is Sparse, filling only fields Primary
+// left empty. If Secondary fails too, Primary's result is returned.
+type Supplement struct {
+	Primary, Secondary Provider
+
+	// Logf, if set, receives a notice each time Secondary is consulted.
+	Logf func(format string, args ...interface{})
+}
+
+// Name returns the provider names joined with a plus sign.
+func (s *Supplement) Name() string {
+	return s.Primary.Name() + "+" + s.Secondary.Name()
+}
+
+// Lookup queries Primary, then Secondary if Primary's answer is empty.
+func (s *Supplement) Lookup(ctx context.Context, domain string) (*Record, error) {
+	record, err := s.Primary.Lookup(ctx, domain)
+	switch {
+	case err == nil && !record.Sparse():
+		return record, nil
+	case err != nil && !errors.Is(err, ErrDomainNotFound):
+		return nil, err
+	}
+
+	s.logf("%s: %s has no data, trying %s", domain, s.Primary.Name(), s.Secondary.Name())
+	extra, extraErr := s.Secondary.Lookup(ctx, domain)
+	if extraErr != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !errors.Is(extraErr, ErrDomainNotFound) {
+			s.logf("%s: warning: %s failed: %v", domain, s.Secondary.Name(), extraErr)
+		}
+		return record, err
+	}
+	if err != nil {
+		return extra, nil
+	}
+
+	merged, mergeErr := mergeRecords(record, extra)
+	if mergeErr != nil {
+		return record, nil
+	}
+	merged.Provider = s.Name()
+	return merged, nil
+}
+
+func (s *Supplement) logf(format string, args ...interface{}) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+// Sparse reports whether the record holds none of the core WHOIS fields: no
+// dates, registrar or nameservers. Some registries answer with little more
+// than the domain name.
+func (r *Record) Sparse() bool {
+	return r.CreateDate == nil && r.UpdateDate == nil && r.ExpireDate == nil &&
+		r.Registrar.Name == "" && len(r.Nameservers) == 0
+}
+
+// Fill fields that are empty in the primary record from the secondary one
+func mergeRecords(primary, secondary *Record) (*Record, error) {
+	merged := fillEmpty(primary.Raw, secondary.Raw)
+	body, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	record, err := decodeRecord(body)
+	if err != nil {
+		return nil, err
+	}
+	record.KeyIndex = primary.KeyIndex
+	record.Cached = primary.Cached
+	return record, nil
+}
+
+// Copy dst, taking each field that is missing or empty in it from src
+func fillEmpty(dst, src map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(dst))
+	for key, value := range dst {
+		merged[key] = value
+	}
+	for key, value := range src {
+		switch c := merged[key].(type) {
+		case map[string]interface{}:
+			if s, isMap := value.(map[string]interface{}); isMap {
+				merged[key] = fillEmpty(c, s)
+			}
+		case string:
+			if c == "" {
+				merged[key] = value
+			}
+		case []interface{}:
+			if len(c) == 0 {
+				merged[key] = value
+			}
+		case float64:
+			if c == 0 {
+				merged[key] = value
+			}
+		case nil:
+			merged[key] = value
+		}
+	}
+	return merged
+}
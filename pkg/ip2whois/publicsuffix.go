@@ -0,0 +1,18 @@
+package ip2whois
+
+import (
+	"fmt"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// RegistrableDomain reduces a normalized domain to its eTLD+1, the public
+// suffix plus one label, so "mail.example.co.uk" becomes "example.co.uk".
+// Suffixes come from the Public Suffix List.
+func RegistrableDomain(domain string) (string, error) {
+	registrable, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return "", fmt.Errorf("%w %q: domain is a public suffix", ErrInvalidDomain, domain)
+	}
+	return registrable, nil
+}
@@ -0,0 +1,46 @@
+package ip2whois
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter spaces requests evenly so their aggregate rate stays under a
+// requests-per-second cap. It is safe for concurrent use.
+type RateLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time // earliest time the next request may be sent
+}
+
+// NewRateLimiter returns a limiter allowing rps requests per second.
+func NewRateLimiter(rps float64) *RateLimiter {
+	return &RateLimiter{interval: time.Duration(float64(time.Second) / rps)}
+}
+
+// Wait blocks until the next request may be sent or ctx is done.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
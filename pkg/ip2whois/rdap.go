@@ -0,0 +1,346 @@
+package ip2whois
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultRDAPURL is the RDAP bootstrap service, which redirects each query to
+// the registry responsible for the domain's TLD.
+const DefaultRDAPURL = "https://rdap.org/"
+
+// RDAP looks up domains over the Registration Data Access Protocol, which
+// needs no API key. Responses are mapped onto the IP2Whois field names so
+// records from either provider look alike; Body keeps the RDAP response as
+// received. It is safe for concurrent use.
+type RDAP struct {
+	HTTPClient *http.Client
+
+	// BaseURL is the RDAP service queried as BaseURL/domain/<name>.
+	BaseURL string
+
+	// UserAgent, if set, replaces Go's default User-Agent header.
+	UserAgent string
+
+	// Header holds extra headers sent with every request.
+	Header http.Header
+
+	// MaxResponseSize caps how many bytes of a response body are read, or
+	// is unlimited if zero.
+	MaxResponseSize int64
+}
+
+// NewRDAP returns an RDAP provider using DefaultRDAPURL with a 30 second
+// timeout and a DefaultMaxResponseSize body limit.
+func NewRDAP() *RDAP {
+	return &RDAP{
+		HTTPClient:      &http.Client{Timeout: 30 * time.Second},
+		BaseURL:         DefaultRDAPURL,
+		MaxResponseSize: DefaultMaxResponseSize,
+	}
+}
+
+// Name returns "rdap".
+func (r *RDAP) Name() string {
+	return "rdap"
+}
+
+// Lookup fetches the RDAP record for a domain, normalizing it first. A 404
+// from the registry is reported as ErrDomainNotFound.
+func (r *RDAP) Lookup(ctx context.Context, domain string) (*Record, error) {
+	normalized, err := NormalizeDomain(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	reqURL, err := r.RequestURL(normalized)
+	if err != nil {
+		return nil, err
+	}
+	body, err := r.fetch(ctx, reqURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var response rdapDomain
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, invalidResponse(body, err)
+	}
+
+	mapped, err := json.Marshal(response.toIP2Whois(normalized, time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	record, err := decodeRecord(mapped)
+	if err != nil {
+		return nil, err
+	}
+	record.Body = body
+	record.Provider = r.Name()
+	return record, nil
+}
+
+// RequestURL returns the URL a lookup of an already normalized domain would
+// request. It makes no network calls.
+func (r *RDAP) RequestURL(domain string) (string, error) {
+	base, err := url.Parse(r.BaseURL)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(&url.URL{Path: "domain/" + domain}).String(), nil
+}
+
+// Fetch the RDAP document, mapping a 404 to ErrDomainNotFound
+func (r *RDAP) fetch(ctx context.Context, reqURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/rdap+json, application/json")
+	if r.UserAgent != "" {
+		req.Header.Set("User-Agent", r.UserAgent)
+	}
+	for name, values := range r.Header {
+		req.Header[name] = values
+	}
+
+	resp, err := r.HTTPClient.Do(req)
+	if err != nil {
+		return nil, timeoutError(ctx, err, r.HTTPClient.Timeout)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%w: no RDAP record", ErrDomainNotFound)
+	case resp.StatusCode != 200:
+		return nil, &StatusError{
+			Code:       resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	var reader io.Reader = resp.Body
+	if r.MaxResponseSize > 0 {
+		reader = io.LimitReader(resp.Body, r.MaxResponseSize+1)
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, timeoutError(ctx, err, r.HTTPClient.Timeout)
+	}
+	if r.MaxResponseSize > 0 && int64(len(body)) > r.MaxResponseSize {
+		return nil, fmt.Errorf("%w: body exceeds %d bytes", ErrResponseTooLarge, r.MaxResponseSize)
+	}
+	return body, nil
+}
+
+// The parts of an RDAP domain response (RFC 9083) we map
+type rdapDomain struct {
+	LDHName     string       `json:"ldhName"`
+	Handle      string       `json:"handle"`
+	Status      []string     `json:"status"`
+	Port43      string       `json:"port43"`
+	Events      []rdapEvent  `json:"events"`
+	Entities    []rdapEntity `json:"entities"`
+	Nameservers []struct {
+		LDHName string `json:"ldhName"`
+	} `json:"nameservers"`
+}
+
+type rdapEvent struct {
+	Action string `json:"eventAction"`
+	Date   string `json:"eventDate"`
+}
+
+type rdapEntity struct {
+	Roles     []string        `json:"roles"`
+	VCard     json.RawMessage `json:"vcardArray"`
+	Entities  []rdapEntity    `json:"entities"` // such as the registrar's abuse contact
+	PublicIDs []struct {
+		Type       string `json:"type"`
+		Identifier string `json:"identifier"`
+	} `json:"publicIds"`
+}
+
+// RDAP entity roles and the IP2Whois contact blocks they fill
+var rdapContactRoles = map[string]string{
+	"registrant":     "registrant",
+	"administrative": "admin",
+	"technical":      "tech",
+	"billing":        "billing",
+}
+
+// Build a response shaped like the IP2Whois API's
+func (d *rdapDomain) toIP2Whois(domain string, now time.Time) map[string]interface{} {
+	if d.LDHName != "" {
+		domain = strings.ToLower(d.LDHName)
+	}
+
+	// RDAP statuses are spelled out, as in "client transfer prohibited",
+	// where WHOIS uses EPP codes such as clientTransferProhibited
+	statuses := make([]string, len(d.Status))
+	for i, status := range d.Status {
+		words := strings.Fields(status)
+		for j, word := range words {
+			if j > 0 {
+				words[j] = strings.ToUpper(word[:1]) + word[1:]
+			}
+		}
+		statuses[i] = strings.Join(words, "")
+	}
+
+	result := map[string]interface{}{
+		"domain":       domain,
+		"domain_id":    d.Handle,
+		"status":       strings.Join(statuses, " "),
+		"create_date":  "",
+		"update_date":  "",
+		"expire_date":  "",
+		"domain_age":   0,
+		"whois_server": d.Port43,
+		"registrar":    Registrar{},
+		"registrant":   Contact{},
+		"admin":        Contact{},
+		"tech":         Contact{},
+		"billing":      Contact{},
+	}
+
+	for _, event := range d.Events {
+		switch event.Action {
+		case "registration":
+			result["create_date"] = event.Date
+			if created, err := ParseDate(event.Date); err == nil {
+				result["domain_age"] = int(now.Sub(created).Hours() / 24)
+			}
+		case "last changed":
+			result["update_date"] = event.Date
+		case "expiration":
+			result["expire_date"] = event.Date
+		}
+	}
+
+	for _, entity := range d.Entities {
+		for _, role := range entity.Roles {
+			if role == "registrar" {
+				registrar := Registrar{Name: entity.vcardText("fn"), URL: entity.vcardText("url")}
+				for _, id := range entity.PublicIDs {
+					if id.Type == "IANA Registrar ID" {
+						registrar.IANAID = id.Identifier
+					}
+				}
+				for _, nested := range entity.Entities {
+					for _, nestedRole := range nested.Roles {
+						if nestedRole == "abuse" {
+							abuse := nested.contact()
+							registrar.AbuseEmail, registrar.AbusePhone = abuse.Email, abuse.Phone
+						}
+					}
+				}
+				result["registrar"] = registrar
+			} else if block, ok := rdapContactRoles[role]; ok {
+				result[block] = entity.contact()
+			}
+		}
+	}
+
+	nameservers := make([]string, 0, len(d.Nameservers))
+	for _, ns := range d.Nameservers {
+		if ns.LDHName != "" {
+			nameservers = append(nameservers, ns.LDHName)
+		}
+	}
+	result["nameservers"] = nameservers
+
+	return result
+}
+
+// Decode the entity's jCard into its properties, each a name followed by
+// parameters, a value type and one or more values
+func (e *rdapEntity) vcard() [][]interface{} {
+	var card []interface{}
+	if err := json.Unmarshal(e.VCard, &card); err != nil || len(card) < 2 {
+		return nil
+	}
+	items, _ := card[1].([]interface{})
+
+	var properties [][]interface{}
+	for _, item := range items {
+		if property, ok := item.([]interface{}); ok && len(property) >= 4 {
+			properties = append(properties, property)
+		}
+	}
+	return properties
+}
+
+// Return the first text value of a jCard property, or ""
+func (e *rdapEntity) vcardText(name string) string {
+	for _, property := range e.vcard() {
+		if property[0] == name {
+			if text, ok := property[3].(string); ok {
+				return text
+			}
+		}
+	}
+	return ""
+}
+
+// Map a contact entity's jCard onto a Contact
+func (e *rdapEntity) contact() Contact {
+	contact := Contact{
+		Name:         e.vcardText("fn"),
+		Organization: e.vcardText("org"),
+		Email:        e.vcardText("email"),
+	}
+
+	for _, property := range e.vcard() {
+		switch property[0] {
+		case "tel":
+			// Fax numbers are tel properties typed as fax
+			text, _ := property[3].(string)
+			text = strings.TrimPrefix(text, "tel:")
+			if params, _ := property[1].(map[string]interface{}); strings.Contains(fmt.Sprint(params["type"]), "fax") {
+				contact.Fax = text
+			} else if contact.Phone == "" {
+				contact.Phone = text
+			}
+		case "adr":
+			// The structured address is post office box, extended address,
+			// street, locality, region, postal code and country
+			parts, _ := property[3].([]interface{})
+			field := func(i int) string {
+				if i >= len(parts) {
+					return ""
+				}
+				switch v := parts[i].(type) {
+				case string:
+					return v
+				case []interface{}:
+					lines := make([]string, 0, len(v))
+					for _, line := range v {
+						lines = append(lines, fmt.Sprint(line))
+					}
+					return strings.Join(lines, ", ")
+				}
+				return ""
+			}
+			contact.StreetAddress = field(2)
+			contact.City = field(3)
+			contact.Region = field(4)
+			contact.ZipCode = field(5)
+			contact.Country = field(6)
+
+			// Some registries only give the country as a parameter
+			if params, _ := property[1].(map[string]interface{}); contact.Country == "" && params != nil {
+				contact.Country, _ = params["cc"].(string)
+			}
+		}
+	}
+	return contact
+}
@@ -0,0 +1,143 @@
+package ip2whois
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Record is a WHOIS lookup result following the documented IP2Whois schema.
+// Dates that are missing or in an unrecognized format are left nil.
+type Record struct {
+	Domain      string     `json:"domain"`
+	DomainID    string     `json:"domain_id"`
+	Status      string     `json:"status"`
+	CreateDate  *time.Time `json:"-"`
+	UpdateDate  *time.Time `json:"-"`
+	ExpireDate  *time.Time `json:"-"`
+	DomainAge   int        `json:"domain_age"`
+	WhoisServer string     `json:"whois_server"`
+	Registrar   Registrar  `json:"registrar"`
+	Registrant  Contact    `json:"registrant"`
+	Admin       Contact    `json:"admin"`
+	Tech        Contact    `json:"tech"`
+	Billing     Contact    `json:"billing"`
+	Nameservers []string   `json:"nameservers"` // lowercased, de-duplicated and sorted
+
+	// Raw is the decoded response, including fields not mapped above.
+	Raw map[string]interface{} `json:"-"`
+
+	// Body is the response exactly as returned by the provider, or nil
+	// when Client.DiscardBody is set.
+	Body []byte `json:"-"`
+
+	// Provider is the Name of the provider that returned the record.
+	Provider string `json:"-"`
+
+	// KeyIndex is the position in Client.Keys of the key that served the request.
+	KeyIndex int `json:"-"`
+
+	// Cached reports whether the record was served from the cache.
+	Cached bool `json:"-"`
+}
+
+// Registrar identifies the registrar a domain is registered through. The
+// abuse contact is only set when the source reports one, as RDAP and most
+// WHOIS servers for gTLDs do.
+type Registrar struct {
+	IANAID     string `json:"iana_id"`
+	Name       string `json:"name"`
+	URL        string `json:"url"`
+	AbuseEmail string `json:"abuse_email,omitempty"`
+	AbusePhone string `json:"abuse_phone,omitempty"`
+}
+
+// Contact is one of the registrant, admin, tech or billing contact blocks.
+type Contact struct {
+	Name          string `json:"name"`
+	Organization  string `json:"organization"`
+	StreetAddress string `json:"street_address"`
+	City          string `json:"city"`
+	Region        string `json:"region"`
+	ZipCode       string `json:"zip_code"`
+	Country       string `json:"country"`
+	Phone         string `json:"phone"`
+	Fax           string `json:"fax"`
+	Email         string `json:"email"`
+}
+
+// Decode a response body into its typed and raw forms
+func decodeRecord(body []byte) (*Record, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("Error parsing JSON: %v", err)
+	}
+	return newRecord(body, raw), nil
+}
+
+// Build a record from a body and its already decoded raw form, which the
+// typed fields are filled from without decoding the body again
+func newRecord(body []byte, raw map[string]interface{}) *Record {
+	record := &Record{Body: body, Raw: raw}
+
+	// Registrars don't always follow the documented types; a mismatch only
+	// leaves the affected field empty, and Raw still holds everything
+	fillStruct(reflect.ValueOf(record).Elem(), raw)
+
+	record.CreateDate = parseOptionalDate(stringValue(raw["create_date"]))
+	record.UpdateDate = parseOptionalDate(stringValue(raw["update_date"]))
+	record.ExpireDate = parseOptionalDate(stringValue(raw["expire_date"]))
+
+	record.Nameservers = NormalizeNameservers(record.Raw["nameservers"])
+
+	return record
+}
+
+// Set the string, integer and nested struct fields of v from the object
+// entries named by their json tags, skipping values of another type
+func fillStruct(v reflect.Value, object map[string]interface{}) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		value, ok := object[name]
+		if name == "" || name == "-" || !ok {
+			continue
+		}
+
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			if s, ok := value.(string); ok {
+				field.SetString(s)
+			}
+		case reflect.Int:
+			if n, ok := value.(float64); ok && n == math.Trunc(n) {
+				field.SetInt(int64(n))
+			}
+		case reflect.Struct:
+			if nested, ok := value.(map[string]interface{}); ok {
+				fillStruct(field, nested)
+			}
+		}
+	}
+}
+
+func stringValue(value interface{}) string {
+	s, _ := value.(string)
+	return s
+}
+
+// Parse a date field, returning nil when it is empty or unrecognized
+func parseOptionalDate(value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	t, err := ParseDate(value)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
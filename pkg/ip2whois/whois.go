@@ -0,0 +1,393 @@
+package ip2whois
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultWHOISServer is asked which WHOIS server is authoritative for a TLD.
+const DefaultWHOISServer = "whois.iana.org"
+
+// WHOIS queries WHOIS servers directly over TCP port 43, as the classic
+// whois command does. It needs no API key but is slower than the API, and
+// servers rate limit aggressively. It is safe for concurrent use.
+type WHOIS struct {
+	// Server is asked first, and its referral followed to the registry.
+	// Addresses without a port use port 43.
+	Server string
+
+	// Timeout bounds each connection, from dialing to reading the reply.
+	Timeout time.Duration
+
+	// MaxResponseSize caps how many bytes of a reply are read, or is
+	// unlimited if zero.
+	MaxResponseSize int64
+
+	registries sync.Map // TLD to the registry's server, learned from Server
+}
+
+// WHOISResponse is the reply of one server to a WHOIS query.
+type WHOISResponse struct {
+	Server string
+	Text   string
+}
+
+// NewWHOIS returns a WHOIS client starting at DefaultWHOISServer with a 30
+// second timeout and a DefaultMaxResponseSize limit.
+func NewWHOIS() *WHOIS {
+	return &WHOIS{
+		Server:          DefaultWHOISServer,
+		Timeout:         30 * time.Second,
+		MaxResponseSize: DefaultMaxResponseSize,
+	}
+}
+
+// Query fetches the WHOIS text for a domain, normalizing it first. Server
+// is asked for the TLD's registry, the registry for the domain, and then
+// the registrar's server if the registry refers to one. The registry's and
+// registrar's replies are returned in that order.
+func (w *WHOIS) Query(ctx context.Context, domain string) ([]WHOISResponse, error) {
+	normalized, err := NormalizeDomain(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	tld := normalized[strings.LastIndex(normalized, ".")+1:]
+	registry, err := w.registry(ctx, tld)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := w.ask(ctx, registry, normalized)
+	if err != nil {
+		return nil, err
+	}
+	responses := []WHOISResponse{{Server: registry, Text: text}}
+
+	// Thin registries such as .com's only hold a pointer to the registrar
+	registrar := referral(text, "Registrar WHOIS Server:", "Whois Server:")
+	if registrar != "" && !strings.EqualFold(registrar, registry) {
+		text, err := w.ask(ctx, registrar, normalized)
+		if err != nil {
+			// The registry's reply is still worth returning
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return responses, nil
+		}
+		responses = append(responses, WHOISResponse{Server: registrar, Text: text})
+	}
+
+	return responses, nil
+}
+
+// Ask Server which server is the TLD's registry, remembering the answer
+func (w *WHOIS) registry(ctx context.Context, tld string) (string, error) {
+	if server, ok := w.registries.Load(tld); ok {
+		return server.(string), nil
+	}
+
+	bootstrap, err := w.ask(ctx, w.Server, tld)
+	if err != nil {
+		return "", err
+	}
+	server := referral(bootstrap, "refer:", "whois:")
+	if server == "" {
+		return "", fmt.Errorf("%s has no WHOIS server for .%s", w.Server, tld)
+	}
+	w.registries.Store(tld, server)
+	return server, nil
+}
+
+// Send one query to a server and read the reply until it closes the connection
+func (w *WHOIS) ask(ctx context.Context, server, query string) (string, error) {
+	addr := server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		addr = net.JoinHostPort(server, "43")
+	}
+
+	parent := ctx
+	if w.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.Timeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return "", w.wrapError(parent, server, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Unblock the read if the context is cancelled without a deadline
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	if _, err := io.WriteString(conn, query+"\r\n"); err != nil {
+		return "", w.wrapError(parent, server, err)
+	}
+
+	var reader io.Reader = conn
+	if w.MaxResponseSize > 0 {
+		reader = io.LimitReader(conn, w.MaxResponseSize+1)
+	}
+	reply, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", w.wrapError(parent, server, err)
+	}
+	if w.MaxResponseSize > 0 && int64(len(reply)) > w.MaxResponseSize {
+		return "", fmt.Errorf("%w: reply from %s exceeds %d bytes", ErrResponseTooLarge, server, w.MaxResponseSize)
+	}
+	return strings.ReplaceAll(string(reply), "\r\n", "\n"), nil
+}
+
+// Name the server in network errors, reporting timeouts as ErrTimeout
+func (w *WHOIS) wrapError(parent context.Context, server string, err error) error {
+	// Cancellation is the caller's doing, not a slow server
+	if parent.Err() != nil {
+		return parent.Err()
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%s: %w after %s", server, ErrTimeout, w.Timeout)
+	}
+	return fmt.Errorf("%s: %w", server, err)
+}
+
+// Find the first "Name: value" line with one of the names, ignoring case,
+// and return its value stripped of any URL scheme
+func referral(text string, names ...string) string {
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		for _, name := range names {
+			if len(line) > len(name) && strings.EqualFold(line[:len(name)], name) {
+				value := strings.TrimSpace(line[len(name):])
+				value = strings.TrimPrefix(value, "whois://")
+				if value != "" {
+					return strings.TrimSuffix(value, "/")
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// Name returns "whois".
+func (w *WHOIS) Name() string {
+	return "whois"
+}
+
+// Lookup queries the domain with Query and parses the common fields of the
+// replies into the IP2Whois field names. WHOIS text has no fixed format, so
+// fields a registry words differently are left empty; Body holds the replies
+// as received. A reply saying the domain isn't registered is reported as
+// ErrDomainNotFound.
+func (w *WHOIS) Lookup(ctx context.Context, domain string) (*Record, error) {
+	responses, err := w.Query(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	if notRegistered(responses[0].Text) {
+		return nil, fmt.Errorf("%w: %s has no record", ErrDomainNotFound, responses[0].Server)
+	}
+
+	normalized, _ := NormalizeDomain(domain)
+	mapped, err := json.Marshal(parseWHOIS(normalized, responses, time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	record, err := decodeRecord(mapped)
+	if err != nil {
+		return nil, err
+	}
+
+	texts := make([]string, len(responses))
+	for i, response := range responses {
+		texts[i] = response.Text
+	}
+	record.Body = []byte(strings.Join(texts, "\n"))
+	record.Provider = w.Name()
+	return record, nil
+}
+
+// Phrases registries use to say a domain isn't registered
+var notFoundPhrases = []string{
+	"no match for",
+	"not found",
+	"no data found",
+	"no entries found",
+	"no object found",
+	"status: free",
+	"status: available",
+	"the queried object does not exist",
+}
+
+// Report whether a registry's reply says the domain isn't registered
+func notRegistered(text string) bool {
+	lowered := strings.ToLower(text)
+	for _, phrase := range notFoundPhrases {
+		if strings.Contains(lowered, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// WHOIS labels, lowercased, and the IP2Whois fields they fill
+var whoisFields = map[string]string{
+	"domain name":                            "domain",
+	"registry domain id":                     "domain_id",
+	"creation date":                          "create_date",
+	"created":                                "create_date",
+	"registered on":                          "create_date",
+	"updated date":                           "update_date",
+	"last updated":                           "update_date",
+	"changed":                                "update_date",
+	"registry expiry date":                   "expire_date",
+	"registrar registration expiration date": "expire_date",
+	"expiry date":                            "expire_date",
+	"expiration date":                        "expire_date",
+	"paid-till":                              "expire_date",
+	"registrar whois server":                 "whois_server",
+	"registrar":                              "registrar.name",
+	"registrar iana id":                      "registrar.iana_id",
+	"registrar url":                          "registrar.url",
+	"registrar abuse contact email":          "registrar.abuse_email",
+	"registrar abuse contact phone":          "registrar.abuse_phone",
+}
+
+// Contact label prefixes and suffixes, as in "Registrant Postal Code"
+var (
+	whoisContactPrefixes = map[string]string{
+		"registrant": "registrant",
+		"admin":      "admin",
+		"tech":       "tech",
+		"billing":    "billing",
+	}
+	whoisContactFields = map[string]string{
+		"name":           "name",
+		"organization":   "organization",
+		"street":         "street_address",
+		"city":           "city",
+		"state/province": "region",
+		"postal code":    "zip_code",
+		"country":        "country",
+		"phone":          "phone",
+		"fax":            "fax",
+		"email":          "email",
+	}
+)
+
+// Build a response shaped like the IP2Whois API's from WHOIS replies. The
+// registrar's reply is usually the more complete, so it is read first and
+// the registry's only fills what it left out.
+func parseWHOIS(domain string, responses []WHOISResponse, now time.Time) map[string]interface{} {
+	contact := func() map[string]interface{} {
+		return map[string]interface{}{"name": "", "organization": "", "street_address": "", "city": "",
+			"region": "", "zip_code": "", "country": "", "phone": "", "fax": "", "email": ""}
+	}
+	result := map[string]interface{}{
+		"domain":       domain,
+		"domain_id":    "",
+		"status":       "",
+		"create_date":  "",
+		"update_date":  "",
+		"expire_date":  "",
+		"domain_age":   0,
+		"whois_server": "",
+		"registrar":    map[string]interface{}{"iana_id": "", "name": "", "url": ""},
+		"registrant":   contact(),
+		"admin":        contact(),
+		"tech":         contact(),
+		"billing":      contact(),
+	}
+	set := func(path, value string) {
+		object := result
+		if i := strings.Index(path, "."); i >= 0 {
+			object = result[path[:i]].(map[string]interface{})
+			path = path[i+1:]
+		}
+		if current, _ := object[path].(string); current == "" {
+			object[path] = value
+		}
+	}
+
+	var statuses, nameservers []string
+	seen := make(map[string]bool)
+	for i := len(responses) - 1; i >= 0; i-- {
+		scanner := bufio.NewScanner(strings.NewReader(responses[i].Text))
+		for scanner.Scan() {
+			label, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+			value = strings.TrimSpace(value)
+			if !ok || value == "" {
+				continue
+			}
+			label = strings.ToLower(strings.TrimSpace(label))
+
+			switch label {
+			case "domain status", "status":
+				// Keep the EPP code, dropping its ICANN reference URL
+				code := strings.Fields(value)[0]
+				if !seen["status "+code] {
+					seen["status "+code] = true
+					statuses = append(statuses, code)
+				}
+				continue
+			case "name server", "nameserver", "nserver":
+				ns := strings.ToLower(strings.Fields(value)[0])
+				if !seen["ns "+ns] {
+					seen["ns "+ns] = true
+					nameservers = append(nameservers, ns)
+				}
+				continue
+			}
+
+			if field, ok := whoisFields[label]; ok {
+				set(field, value)
+				continue
+			}
+			if prefix, rest, ok := strings.Cut(label, " "); ok {
+				if block, ok := whoisContactPrefixes[prefix]; ok {
+					if field, ok := whoisContactFields[rest]; ok {
+						set(block+"."+field, value)
+					}
+				}
+			}
+		}
+	}
+
+	// Registries often spell the domain in capitals
+	result["domain"] = domain
+	result["status"] = strings.Join(statuses, " ")
+	if nameservers == nil {
+		nameservers = []string{}
+	}
+	result["nameservers"] = nameservers
+	set("whois_server", responses[len(responses)-1].Server)
+	if created, err := ParseDate(result["create_date"].(string)); err == nil {
+		result["domain_age"] = int(now.Sub(created).Hours() / 24)
+	}
+	return result
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Where diagnostics are written, so they never land in the middle of a live
+// progress bar
+var stderr io.Writer = barWriter{os.Stderr}
+
+// The bar on screen, if any, which barWriter clears before writing
+var (
+	liveBarMu sync.Mutex
+	liveBar   *progress
+)
+
+// How often the live bar is redrawn, and how often progress lines are
+// logged when stderr isn't a terminal
+const (
+	progressRedraw   = 200 * time.Millisecond
+	progressInterval = 30 * time.Second
+	progressWidth    = 30
+)
+
+// Tracks how many domains are done for the progress display. It is safe for
+// concurrent use by the workers.
+type progress struct {
+	mu      sync.Mutex
+	total   int // 0 when the input is streamed and the total is unknown
+	done    int
+	started time.Time
+	live    bool // draw a bar on a terminal rather than log lines
+	drawn   bool // the bar is on screen and must be cleared before writing
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// Report whether stderr is a terminal a live bar can be drawn on
+func stderrIsTerminal() bool {
+	info, err := os.Stderr.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Start displaying progress towards total domains: a live bar on a terminal,
+// or a line every progressInterval otherwise
+func startProgress(total int) *progress {
+	p := &progress{
+		total:   total,
+		started: time.Now(),
+		live:    jsonLog == nil && stderrIsTerminal(),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	interval := progressInterval
+	if p.live {
+		interval = progressRedraw
+		setLiveBar(p)
+	}
+
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.mu.Lock()
+				if p.live {
+					p.draw()
+					p.mu.Unlock()
+					continue
+				}
+				status := p.status()
+				p.mu.Unlock()
+				logf("Progress: %s", status)
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// Count a finished domain
+func (p *progress) advance() {
+	p.mu.Lock()
+	p.done++
+	p.mu.Unlock()
+}
+
+// Stop updating and remove the bar, so later output starts on a clean line
+func (p *progress) finish() {
+	close(p.stop)
+	<-p.stopped
+
+	if p.live {
+		setLiveBar(nil)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+}
+
+// Describe the count, rate and estimated time left
+func (p *progress) status() string {
+	elapsed := time.Since(p.started)
+	rate := float64(p.done) / elapsed.Seconds()
+
+	var b strings.Builder
+	if p.total > 0 {
+		fmt.Fprintf(&b, "%d/%d domain(s) (%d%%)", p.done, p.total, 100*p.done/p.total)
+	} else {
+		fmt.Fprintf(&b, "%d domain(s)", p.done)
+	}
+	fmt.Fprintf(&b, ", %.1f/s", rate)
+	if p.total > 0 && p.done > 0 && p.done < p.total {
+		left := time.Duration(float64(p.total-p.done) / rate * float64(time.Second))
+		fmt.Fprintf(&b, ", ETA %s", left.Round(time.Second))
+	}
+	return b.String()
+}
+
+// Redraw the bar in place; the caller holds p.mu
+func (p *progress) draw() {
+	bar := ""
+	if p.total > 0 {
+		filled := progressWidth * p.done / p.total
+		bar = "[" + strings.Repeat("=", filled) + strings.Repeat(" ", progressWidth-filled) + "] "
+	}
+	fmt.Fprintf(os.Stderr, "\r\x1b[K%s%s", bar, p.status())
+	p.drawn = true
+}
+
+// Erase the bar; the caller holds p.mu
+func (p *progress) clear() {
+	if p.drawn {
+		fmt.Fprint(os.Stderr, "\r\x1b[K")
+		p.drawn = false
+	}
+}
+
+func setLiveBar(p *progress) {
+	liveBarMu.Lock()
+	defer liveBarMu.Unlock()
+	liveBar = p
+}
+
+// Writes to a terminal the live bar may be on, clearing the bar before each
+// write and redrawing it after a complete line
+type barWriter struct {
+	out io.Writer
+}
+
+func (w barWriter) Write(data []byte) (int, error) {
+	liveBarMu.Lock()
+	defer liveBarMu.Unlock()
+	if liveBar == nil {
+		return w.out.Write(data)
+	}
+
+	liveBar.mu.Lock()
+	defer liveBar.mu.Unlock()
+	liveBar.clear()
+	n, err := w.out.Write(data)
+	if strings.HasSuffix(string(data), "\n") {
+		liveBar.draw()
+	}
+	return n, err
+}
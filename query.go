@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/itchyny/gojq"
+	"github.com/xhzeem/ip2whois/pkg/ip2whois"
+)
+
+// A compiled -query expression in jq syntax, producing zero or more results
+// per input
+type query func(input interface{}) ([]interface{}, error)
+
+// Parse and compile an expression, reporting syntax errors before any lookup runs
+func compileQuery(expr string) (query, error) {
+	parsed, err := gojq.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	code, err := gojq.Compile(parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(input interface{}) ([]interface{}, error) {
+		var results []interface{}
+		iter := code.Run(input)
+		for {
+			result, ok := iter.Next()
+			if !ok {
+				return results, nil
+			}
+			if err, ok := result.(error); ok {
+				// halt stops the expression without failing it
+				var halt *gojq.HaltError
+				if errors.As(err, &halt) && halt.Value() == nil {
+					return results, nil
+				}
+				return nil, err
+			}
+			results = append(results, result)
+		}
+	}, nil
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Format a value as compact JSON
+func describeValue(value interface{}) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
+
+// Prints the results of a -query expression, strings unquoted as with jq -r
+type queryWriter struct {
+	w io.Writer
+	q query
+}
+
+func (qw *queryWriter) write(value string, record *ip2whois.Record, jsonData map[string]interface{}) error {
+	input, err := roundTripJSON(jsonData)
+	if err != nil {
+		return err
+	}
+
+	results, err := qw.q(input)
+	if err != nil {
+		return fmt.Errorf("query: %v", err)
+	}
+	for _, result := range results {
+		line, ok := result.(string)
+		if !ok {
+			line = describeValue(result)
+		}
+		if _, err := fmt.Fprintln(qw.w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (qw *queryWriter) close() error {
+	return nil
+}
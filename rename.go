@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// A -rename mapping from a dotted source path to a top-level output key
+type fieldRename struct {
+	source []string
+	target string
+}
+
+// Load a -rename file of "source.path: targetKey" lines or a JSON object,
+// the same formats as the config file
+func loadRenames(path string) ([]fieldRename, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(mapping) == 0 {
+		return nil, fmt.Errorf("%s: no fields to rename", path)
+	}
+
+	sources := make([]string, 0, len(mapping))
+	for source := range mapping {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	targets := make(map[string]string)
+	renames := make([]fieldRename, 0, len(sources))
+	for _, source := range sources {
+		target := strings.TrimSpace(mapping[source])
+		if source == "" || target == "" {
+			return nil, fmt.Errorf("%s: %q: source and target must both be set", path, source+": "+target)
+		}
+		if earlier, ok := targets[target]; ok {
+			return nil, fmt.Errorf("%s: %s and %s are both renamed to %s", path, earlier, source, target)
+		}
+		targets[target] = source
+		renames = append(renames, fieldRename{source: strings.Split(source, "."), target: target})
+	}
+	return renames, nil
+}
+
+// Move each mapped field to its target key. Other fields are kept, or with
+// strict dropped, so only the targets remain. Fields missing from the record
+// are skipped.
+func renameFields(data map[string]interface{}, renames []fieldRename, strict bool) map[string]interface{} {
+	// Look every source up before moving anything, so renames can't chain
+	values := make(map[string]interface{}, len(renames))
+	var moved []string
+	for _, rename := range renames {
+		if value, ok := lookupPath(data, rename.source); ok {
+			values[rename.target] = value
+			moved = append(moved, strings.Join(rename.source, "."))
+		}
+	}
+
+	if strict {
+		return values
+	}
+	data = excludeFields(data, moved)
+	for target, value := range values {
+		data[target] = value
+	}
+	return data
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Records completed domains so an interrupted batch can pick up where it left off
+type checkpoint struct {
+	file *os.File
+	done map[string]bool
+}
+
+// Load the domains completed by earlier runs and open the file for appending
+func openCheckpoint(path string) (*checkpoint, error) {
+	done := make(map[string]bool)
+	lines, err := readLinesFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, line := range lines {
+		done[line] = true
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &checkpoint{file: file, done: done}, nil
+}
+
+// Report whether an earlier run already completed the domain
+func (c *checkpoint) completed(d string) bool {
+	return c.done[d]
+}
+
+// Append a completed domain, unbuffered so it survives a crash
+func (c *checkpoint) record(d string) error {
+	_, err := fmt.Fprintln(c.file, d)
+	return err
+}
+
+func (c *checkpoint) close() error {
+	return c.file.Close()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// How many of total domains -sample or -sample-pct selects: the count capped
+// at total, or the percentage rounded up so a small input still yields one
+func sampleSize(total, count int, pct float64) int {
+	if pct > 0 {
+		count = int(math.Ceil(float64(total) * pct / 100))
+	}
+	if count > total {
+		count = total
+	}
+	return count
+}
+
+// Pick n domains at random, keeping them in input order so the output lines
+// up with the list being sampled
+func sampleDomains(domains []string, n int, seed int64) []string {
+	picked := rand.New(rand.NewSource(seed)).Perm(len(domains))[:n]
+	sort.Ints(picked)
+
+	sample := make([]string, n)
+	for i, index := range picked {
+		sample[i] = domains[index]
+	}
+	return sample
+}
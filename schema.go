@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// The structure -validate expects domain responses to have
+//
+//go:embed schema.json
+var responseSchemaJSON []byte
+
+// The compiled schema.json, with format assertions on
+var responseSchema = mustCompileSchema(responseSchemaJSON)
+
+// Prints the validator's messages
+var schemaPrinter = message.NewPrinter(language.English)
+
+func mustCompileSchema(data []byte) *jsonschema.Schema {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		panic("schema.json: " + err.Error())
+	}
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat()
+	if err := compiler.AddResource("schema.json", doc); err != nil {
+		panic("schema.json: " + err.Error())
+	}
+	compiled, err := compiler.Compile("schema.json")
+	if err != nil {
+		panic("schema.json: " + err.Error())
+	}
+	return compiled
+}
+
+// Check a response against the schema, returning one violation per field
+// that breaks it, sorted by path
+func validateResponse(data map[string]interface{}) []string {
+	err := responseSchema.Validate(data)
+	if err == nil {
+		return nil
+	}
+	invalid, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return []string{err.Error()}
+	}
+
+	var violations []string
+	collectViolations(invalid, &violations)
+	sort.Strings(violations)
+	return violations
+}
+
+// Report the errors at the leaves of the tree, not the subschemas and $refs
+// that contain them
+func collectViolations(e *jsonschema.ValidationError, violations *[]string) {
+	if len(e.Causes) == 0 {
+		*violations = append(*violations, fmt.Sprintf("%s: %s", instancePath(e.InstanceLocation), e.ErrorKind.LocalizedString(schemaPrinter)))
+		return
+	}
+	for _, cause := range e.Causes {
+		collectViolations(cause, violations)
+	}
+}
+
+// Write an instance location such as [nameservers 0] as nameservers[0], or
+// "response" for the root
+func instancePath(location []string) string {
+	if len(location) == 0 {
+		return "response"
+	}
+	var b strings.Builder
+	for _, part := range location {
+		switch {
+		case part != "" && strings.Trim(part, "0123456789") == "":
+			b.WriteString("[" + part + "]")
+		case b.Len() > 0:
+			b.WriteString("." + part)
+		default:
+			b.WriteString(part)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/xhzeem/ip2whois/pkg/ip2whois"
+)
+
+// What the -serve handlers need to answer a lookup
+type server struct {
+	lookup    func(context.Context, string) (*ip2whois.Record, error)
+	normalize func(string) (string, error)
+	opts      outputOptions
+	raw       bool
+	indent    string
+}
+
+// Answer lookups over HTTP until ctx is done, then let in-flight requests
+// finish for up to interruptGrace
+func (s *server) run(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/whois", s.handleWhois)
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), interruptGrace)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	logf("Serving lookups on %s (GET /whois?domain=example.com, GET /healthz)", addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Handle GET /whois?domain=NAME, answering with the record as JSON
+func (s *server) handleWhois(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		s.writeError(w, http.StatusMethodNotAllowed, "", errors.New("method not allowed"))
+		return
+	}
+
+	d := r.URL.Query().Get("domain")
+	if d == "" {
+		s.writeError(w, http.StatusBadRequest, "", errors.New("missing domain parameter"))
+		return
+	}
+
+	record, err := s.lookup(r.Context(), d)
+
+	// An unregistered domain is an answer rather than a failure, as in batch runs
+	if errors.Is(err, ip2whois.ErrDomainNotFound) {
+		name, normErr := s.normalize(d)
+		if normErr != nil {
+			name = d
+		}
+		record, err = availableRecord(name), nil
+	}
+	if err != nil {
+		// The client went away; there is no one to answer
+		if r.Context().Err() != nil {
+			return
+		}
+		logFailure(d, err)
+		s.writeError(w, errorStatus(err), d, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if s.raw {
+		w.Write(record.Body)
+		return
+	}
+
+	jsonData := prepareRecord(d, record, s.opts)
+	jsonData["query"] = d
+	formatted, err := marshalJSON(jsonData, s.indent)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, d, err)
+		return
+	}
+	w.Write(append(formatted, '\n'))
+}
+
+// Write a failure as the same JSON object -ndjson uses
+func (s *server) writeError(w http.ResponseWriter, status int, value string, err error) {
+	object := errorObject(value, err)
+	if value == "" {
+		delete(object, "domain")
+		delete(object, "query")
+	}
+	formatted, _ := marshalJSON(object, s.indent)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(formatted, '\n'))
+}
+
+// Map a lookup error to the HTTP status that best describes it
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ip2whois.ErrInvalidDomain):
+		return http.StatusBadRequest
+	case errors.Is(err, ip2whois.ErrTimeout):
+		return http.StatusGatewayTimeout
+	}
+	// Exhausted keys, rate limiting and upstream errors are all a bad gateway
+	return http.StatusBadGateway
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// How long in-flight lookups get to finish after the first interrupt
+const interruptGrace = 5 * time.Second
+
+// Handle SIGINT and SIGTERM in two stages. The first calls drain so no new
+// lookups start, then abort once in-flight lookups have had interruptGrace to
+// finish; a second signal exits immediately. The returned flag reports
+// whether a signal arrived.
+func handleInterrupts(drain, abort context.CancelFunc) *atomic.Bool {
+	var interrupted atomic.Bool
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		interrupted.Store(true)
+		drain()
+		logError("\nInterrupted: waiting up to %s for in-flight lookups; interrupt again to exit immediately", interruptGrace)
+
+		select {
+		case <-signals:
+			os.Exit(exitInterrupted)
+		case <-time.After(interruptGrace):
+			abort()
+		}
+	}()
+
+	return &interrupted
+}
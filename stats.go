@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xhzeem/ip2whois/pkg/ip2whois"
+)
+
+// Collects the latency and outcome of every API request for -stats
+type requestStats struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	succeeded int
+}
+
+// Record one attempt; used as a Client.OnAttempt hook
+func (s *requestStats) attempt(attempt ip2whois.Attempt) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latencies = append(s.latencies, attempt.Latency)
+	if attempt.Err == nil {
+		s.succeeded++
+	}
+}
+
+// Return the nearest-rank percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Print the request count, success rate and latency percentiles. They were
+// asked for explicitly, so -q doesn't silence them.
+func (s *requestStats) print() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sorted := append([]time.Duration(nil), s.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rate := 0.0
+	if len(sorted) > 0 {
+		rate = 100 * float64(s.succeeded) / float64(len(sorted))
+	}
+	p50, p90, p99 := percentile(sorted, 50), percentile(sorted, 90), percentile(sorted, 99)
+
+	if jsonLog != nil {
+		jsonLog.Info("stats", "requests", len(sorted), "succeeded", s.succeeded, "success_rate", math.Round(rate*10)/10,
+			"p50_ms", p50.Milliseconds(), "p90_ms", p90.Milliseconds(), "p99_ms", p99.Milliseconds())
+		return
+	}
+
+	fmt.Fprintf(stderr, "\nStats: %d request(s), %d succeeded (%.1f%%)\n", len(sorted), s.succeeded, rate)
+	if len(sorted) > 0 {
+		fmt.Fprintf(stderr, "Latency: p50 %s, p90 %s, p99 %s\n",
+			p50.Round(time.Millisecond), p90.Round(time.Millisecond), p99.Round(time.Millisecond))
+	}
+}
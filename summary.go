@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/xhzeem/ip2whois/pkg/ip2whois"
+)
+
+// Tallies failures by reason and keys that ran out of credits or were rejected
+type runSummary struct {
+	mu        sync.Mutex
+	reasons   map[string]int
+	exhausted map[int]string // key index to masked key
+	rejected  map[int]string
+}
+
+func newRunSummary() *runSummary {
+	return &runSummary{
+		reasons:   make(map[string]int),
+		exhausted: make(map[int]string),
+		rejected:  make(map[int]string),
+	}
+}
+
+// Note keys the API reported as out of credits or invalid; used as a Client.OnAttempt hook
+func (s *runSummary) attempt(attempt ip2whois.Attempt) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case errors.Is(attempt.Err, ip2whois.ErrQuotaExceeded):
+		s.exhausted[attempt.KeyIndex] = attempt.Key
+	case errors.Is(attempt.Err, ip2whois.ErrInvalidKey):
+		s.rejected[attempt.KeyIndex] = attempt.Key
+	}
+}
+
+func (s *runSummary) failure(err error) {
+	s.mu.Lock()
+	s.reasons[failureReason(err)]++
+	s.mu.Unlock()
+}
+
+// Bucket an error by its cause
+func failureReason(err error) string {
+	var statusErr *ip2whois.StatusError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ip2whois.ErrQuotaExceeded):
+		return "quota exceeded"
+	case errors.Is(err, ip2whois.ErrInvalidKey):
+		return "invalid key"
+	case errors.Is(err, ip2whois.ErrInvalidDomain):
+		return "invalid domain"
+	case errors.Is(err, ip2whois.ErrTimeout):
+		return "timeout"
+	case errors.Is(err, ip2whois.ErrInvalidResponse), errors.Is(err, ip2whois.ErrResponseTooLarge):
+		return "invalid response"
+	case errors.As(err, &statusErr) && statusErr.Code == http.StatusTooManyRequests:
+		return "rate limited"
+	case errors.As(err, &statusErr):
+		return "http error"
+	case errors.As(err, &netErr):
+		return "network"
+	}
+	return "other"
+}
+
+// Return the percentage of domains that succeeded, or 100 if there were none
+func successRate(total, failed int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(total-failed) / float64(total)
+}
+
+// Print totals, failures by reason and problem keys
+func (s *runSummary) print(total, failed int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reasons := make([]string, 0, len(s.reasons))
+	for reason := range s.reasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	if jsonLog != nil {
+		byReason := make(map[string]int, len(s.reasons))
+		for reason, n := range s.reasons {
+			byReason[reason] = n
+		}
+		jsonLog.Info("summary", "total", total, "succeeded", total-failed, "failed", failed,
+			"success_rate", math.Round(successRate(total, failed)*10)/10,
+			"reasons", byReason, "exhausted_keys", keyList(s.exhausted), "rejected_keys", keyList(s.rejected))
+		return
+	}
+
+	logf("\nSummary: %d domain(s), %d succeeded (%.1f%%), %d failed", total, total-failed, successRate(total, failed), failed)
+	for _, reason := range reasons {
+		logf("  %s: %d", reason, s.reasons[reason])
+	}
+	if len(s.exhausted) > 0 {
+		logf("Exhausted keys: %s", strings.Join(keyList(s.exhausted), ", "))
+	}
+	if len(s.rejected) > 0 {
+		logf("Rejected keys: %s", strings.Join(keyList(s.rejected), ", "))
+	}
+}
+
+// Format keys as "#1 (abcd****wxyz)" in key order
+func keyList(keys map[int]string) []string {
+	indexes := make([]int, 0, len(keys))
+	for i := range keys {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	list := make([]string, len(indexes))
+	for n, i := range indexes {
+		list[n] = fmt.Sprintf("#%d (%s)", i+1, keys[i])
+	}
+	return list
+}
+
+// Return a copy of the failure counts by reason
+func (s *runSummary) reasonCounts() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]int, len(s.reasons))
+	for reason, n := range s.reasons {
+		counts[reason] = n
+	}
+	return counts
+}
+
+// Describe each request a failed lookup made for -explain, or just the cause
+// if it failed before making any
+func explainFailure(err error) []string {
+	var lookupErr *ip2whois.LookupError
+	if !errors.As(err, &lookupErr) {
+		return []string{"cause: " + failureReason(err)}
+	}
+
+	lines := make([]string, len(lookupErr.Attempts))
+	for i, attempt := range lookupErr.Attempts {
+		result := "ok"
+		if attempt.Err != nil {
+			result = fmt.Sprintf("%s: %v", failureReason(attempt.Err), attempt.Err)
+		}
+		status := "no response"
+		if attempt.Status != 0 {
+			status = fmt.Sprintf("status %d", attempt.Status)
+		}
+		lines[i] = fmt.Sprintf("key #%d (%s): %s, %s, then %s", attempt.KeyIndex+1, attempt.Key, status, result, attempt.Decision)
+	}
+	return lines
+}
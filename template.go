@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/xhzeem/ip2whois/pkg/ip2whois"
+)
+
+// Helpers available to -template. Arguments come in pipeline order, so
+// {{.expire_date | date "2006-01-02"}} and {{.registrar.name | default "-"}} work.
+var templateFuncs = template.FuncMap{
+	// Reformat a WHOIS date with a Go time layout, leaving unparseable values as they are
+	"date": func(layout string, value interface{}) string {
+		s, _ := value.(string)
+		t, err := ip2whois.ParseDate(s)
+		if err != nil {
+			return s
+		}
+		return t.Format(layout)
+	},
+	// Substitute a fallback for missing, null and empty values
+	"default": func(fallback string, value interface{}) interface{} {
+		if value == nil || value == "" {
+			return fallback
+		}
+		return value
+	},
+	"join": func(sep string, value interface{}) string {
+		items, _ := value.([]interface{})
+		parts := make([]string, len(items))
+		for i, item := range items {
+			parts[i] = fmt.Sprint(item)
+		}
+		return strings.Join(parts, sep)
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// Parse a -template, reporting errors before any lookup runs
+func parseTemplate(text string) (*template.Template, error) {
+	// Each record goes on its own line unless the template ends one itself
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return template.New("record").Funcs(templateFuncs).Parse(text)
+}
+
+// Executes a Go template against each record's data
+type templateWriter struct {
+	w    io.Writer
+	tmpl *template.Template
+}
+
+func (tw *templateWriter) write(value string, record *ip2whois.Record, jsonData map[string]interface{}) error {
+	data, err := roundTripJSON(jsonData)
+	if err != nil {
+		return err
+	}
+	data["query"] = value
+
+	if err := tw.tmpl.Execute(tw.w, data); err != nil {
+		return fmt.Errorf("template: %v", err)
+	}
+	return nil
+}
+
+func (tw *templateWriter) close() error {
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// Build the TLS configuration for -ca-cert and -insecure, or nil for Go's defaults
+func tlsConfig(caCertFile string, insecure bool) (*tls.Config, error) {
+	if caCertFile == "" && !insecure {
+		return nil, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if caCertFile != "" {
+		pem, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, err
+		}
+
+		// Trust the extra CA in addition to the system roots
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates found", caCertFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
@@ -0,0 +1,9 @@
+package main
+
+// Build information, set at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xhzeem/ip2whois/pkg/ip2whois"
+)
+
+// Re-queries a fixed set of domains on a timer for -watch, printing the
+// fields that changed since the previous poll
+type watcher struct {
+	lookup      func(context.Context, string) (*ip2whois.Record, error)
+	normalize   func(string) (string, error)
+	concurrency int
+	out         io.Writer
+
+	previous map[string]map[string]interface{} // last successful state per domain
+}
+
+// The parts of a record a transfer or hijack would change
+func watchState(record *ip2whois.Record) (map[string]interface{}, error) {
+	nameservers := append([]string(nil), record.Nameservers...)
+	sort.Strings(nameservers)
+
+	state := map[string]interface{}{
+		"available":   isAvailable(record),
+		"nameservers": nameservers,
+	}
+	for _, field := range []string{"registrar", "status", "expire_date"} {
+		if value, ok := record.Raw[field]; ok {
+			state[field] = value
+		}
+	}
+	// Compare decoded JSON on both sides, as diffValues expects
+	return roundTripJSON(state)
+}
+
+// Poll immediately and then every interval until ctx is done. The first poll
+// only records each domain's state; later ones print what changed.
+func (w *watcher) run(ctx context.Context, domains []string, interval time.Duration) {
+	w.previous = make(map[string]map[string]interface{})
+	logf("Watching %d domain(s) every %s", len(domains), interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for poll := 1; ; poll++ {
+		w.poll(ctx, poll, domains)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Look every domain up once and report the changes in input order
+func (w *watcher) poll(ctx context.Context, poll int, domains []string) {
+	states := make([]map[string]interface{}, len(domains))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < w.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				states[i] = w.check(ctx, domains[i])
+			}
+		}()
+	}
+feed:
+	for i := range domains {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	changed, failed := 0, 0
+	now := time.Now().UTC().Format(time.RFC3339)
+	for i, d := range domains {
+		state := states[i]
+		if state == nil {
+			failed++
+			continue
+		}
+		old, seen := w.previous[d]
+		w.previous[d] = state
+		if !seen {
+			continue
+		}
+
+		if changes := diffValues("", old, state, nil); len(changes) > 0 {
+			changed++
+			if err := writeChanges(w.out, now+" "+d, changes); err != nil {
+				logError("Error writing output: %v", err)
+			}
+		}
+	}
+
+	if poll == 1 {
+		logf("Poll 1: recorded %d domain(s), %d failed", len(domains)-failed, failed)
+	} else {
+		logf("Poll %d: %d domain(s) changed, %d failed", poll, changed, failed)
+	}
+}
+
+// Look a domain up, returning its state or nil if the lookup failed; a failed
+// poll keeps the previous state so it isn't reported as a change
+func (w *watcher) check(ctx context.Context, d string) map[string]interface{} {
+	record, err := w.lookup(ctx, d)
+	if errors.Is(err, ip2whois.ErrDomainNotFound) {
+		name, normErr := w.normalize(d)
+		if normErr != nil {
+			name = d
+		}
+		record, err = availableRecord(name), nil
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			logFailure(d, err)
+		}
+		return nil
+	}
+
+	state, err := watchState(record)
+	if err != nil {
+		logFailure(d, fmt.Errorf("cannot compare record: %v", err))
+		return nil
+	}
+	return state
+}
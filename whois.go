@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/xhzeem/ip2whois/pkg/ip2whois"
+)
+
+// Wrap the text of each server's reply in a record for -whois-text, headed
+// by a WHOIS-style comment naming the server
+func whoisTextRecord(domain string, responses []ip2whois.WHOISResponse) *ip2whois.Record {
+	var text strings.Builder
+	for i, response := range responses {
+		if i > 0 {
+			text.WriteString("\n")
+		}
+		text.WriteString("% " + domain + " from " + response.Server + "\n")
+		text.WriteString(strings.TrimRight(response.Text, "\n") + "\n")
+	}
+	return &ip2whois.Record{
+		Domain:   domain,
+		Body:     []byte(strings.TrimSuffix(text.String(), "\n")),
+		Provider: "whois",
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"io"
+
+	"github.com/xhzeem/ip2whois/pkg/ip2whois"
+	"gopkg.in/yaml.v3"
+)
+
+// Writes each record as a YAML document, separated by "---"
+type yamlWriter struct {
+	w   io.Writer
+	enc *yaml.Encoder
+}
+
+func (yw *yamlWriter) write(value string, record *ip2whois.Record, jsonData map[string]interface{}) error {
+	if yw.enc == nil {
+		yw.enc = yaml.NewEncoder(yw.w)
+		yw.enc.SetIndent(2)
+	}
+	return yw.enc.Encode(jsonData)
+}
+
+func (yw *yamlWriter) close() error {
+	if yw.enc == nil {
+		return nil
+	}
+	return yw.enc.Close()
+}